@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Admins are whoever's public key is in admins.txt, which looks just like an
+// authorized_keys file. They get a console (press ':') for getting around,
+// handing things out and keeping order, and everything they do goes in the
+// log.
+const adminsPath = "./admins.txt"
+
+// loadAdmins reads the allowlist. No file just means no admins.
+func loadAdmins(path string) (map[string]bool, error) {
+	admins := map[string]bool{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return admins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, n, err))
+			continue
+		}
+		admins[keyID(key)] = true
+	}
+	return admins, errors.Join(errs...)
+}
+
+// kickMsg tells a player's program to hang up
+type kickMsg struct {
+}
+
+// muteMsg tells the hub to drop somebody's chat
+type muteMsg struct {
+	id    string
+	muted bool
+}
+
+type adminCommand struct {
+	usage string
+	run   func(m *model, args []string) (string, error)
+}
+
+var adminCommands map[string]adminCommand
+
+func init() {
+	// help looks through the list, so it can't be filled in where it's declared
+	adminCommands = map[string]adminCommand{
+		"help":   {"help", (*model).cmdHelp},
+		"where":  {"where", (*model).cmdWhere},
+		"who":    {"who", (*model).cmdWho},
+		"tp":     {"tp <room> [x y]", (*model).cmdTeleport},
+		"item":   {"item <id or name> [count]", (*model).cmdItem},
+		"enemy":  {"enemy <id or name>", (*model).cmdEnemy},
+		"level":  {"level <n>", (*model).cmdLevel},
+		"heal":   {"heal", (*model).cmdHeal},
+		"kick":   {"kick <player>", (*model).cmdKick},
+		"mute":   {"mute <player> [why]", (*model).cmdMute},
+		"unmute": {"unmute <player>", (*model).cmdUnmute},
+		"ban":    {"ban <player> [why]", (*model).cmdBan},
+		"unban":  {"unban <name or key>", (*model).cmdUnban},
+		"bans":   {"bans", (*model).cmdBans},
+		"reload": {"reload", (*model).cmdReload},
+	}
+}
+
+// updateConsole handles keys while the console is open
+func (m *model) updateConsole(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		line := m.console.Value()
+		m.console.Blur()
+		m.console.SetValue("")
+		m.runCommand(line)
+		return nil
+	case "esc", "ctrl+c":
+		m.console.Blur()
+		m.console.SetValue("")
+		return nil
+	}
+	var cmd tea.Cmd
+	m.console, cmd = m.console.Update(msg)
+	return cmd
+}
+
+// runCommand runs one console line and shows how it went
+func (m *model) runCommand(line string) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	if !m.admin {
+		log.Warn("admin command refused", "id", m.id, "key", m.key, "command", line)
+		return
+	}
+	c, ok := adminCommands[args[0]]
+	if !ok {
+		m.text = fmt.Sprintf("No command %q, try help", args[0])
+		log.Info("admin", "id", m.id, "key", m.key, "command", line, "error", "unknown command")
+		return
+	}
+	result, err := c.run(m, args[1:])
+	if err != nil {
+		m.text = err.Error()
+		log.Info("admin", "id", m.id, "key", m.key, "command", line, "error", err)
+		return
+	}
+	m.text = result
+	log.Info("admin", "id", m.id, "key", m.key, "command", line, "result", result)
+}
+
+func (m *model) cmdHelp(args []string) (string, error) {
+	names := []string{}
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 1 {
+		if c, ok := adminCommands[args[0]]; ok {
+			return c.usage, nil
+		}
+	}
+	return strings.Join(names, " "), nil
+}
+
+func (m *model) cmdWhere(args []string) (string, error) {
+	return fmt.Sprintf("%s at %d,%d", m.pos.world, m.pos.x, m.pos.y), nil
+}
+
+func (m *model) cmdWho(args []string) (string, error) {
+	m.app.StateMutex.RLock()
+	defer m.app.StateMutex.RUnlock()
+	who := []string{}
+	for id, pos := range m.app.Positions {
+		who = append(who, fmt.Sprintf("%s (%s)", m.app.nameOf(id), pos.world))
+	}
+	sort.Strings(who)
+	return fmt.Sprintf("%d here: %s", len(who), strings.Join(who, ", ")), nil
+}
+
+// nameOf is what to call a player in the console: their character's name,
+// or their id if they haven't made one yet. Hold StateMutex.
+func (a *app) nameOf(id string) string {
+	if name := a.Characters[id].Name; name != "" {
+		return name
+	}
+	return id
+}
+
+// playerName is nameOf for when you aren't holding StateMutex
+func (a *app) playerName(id string) string {
+	a.StateMutex.RLock()
+	defer a.StateMutex.RUnlock()
+	return a.nameOf(id)
+}
+
+// findPlayer matches a connected player by name or id, or by any unique
+// piece of either
+func (a *app) findPlayer(query string) (string, error) {
+	a.StateMutex.RLock()
+	defer a.StateMutex.RUnlock()
+	if _, ok := a.Positions[query]; ok {
+		return query, nil
+	}
+	for id := range a.Positions {
+		if strings.EqualFold(a.Characters[id].Name, query) {
+			return id, nil
+		}
+	}
+	found := []string{}
+	lower := strings.ToLower(query)
+	for id := range a.Positions {
+		if strings.Contains(id, query) || strings.Contains(strings.ToLower(a.Characters[id].Name), lower) {
+			found = append(found, id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("Nobody matches %q", query)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%q matches %d players", query, len(found))
+}
+
+func (m *model) cmdTeleport(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return "", fmt.Errorf("usage: %s", adminCommands["tp"].usage)
+	}
+	if m.state != OVERWORLD || m.falling || m.dead {
+		return "", fmt.Errorf("Not right now")
+	}
+	room, ok := m.app.world[args[0]]
+	if !ok {
+		return "", fmt.Errorf("No room %q", args[0])
+	}
+	pos := Position{world: args[0], x: -1}
+	if len(args) == 3 {
+		x, errX := strconv.Atoi(args[1])
+		y, errY := strconv.Atoi(args[2])
+		if errX != nil || errY != nil {
+			return "", fmt.Errorf("usage: %s", adminCommands["tp"].usage)
+		}
+		pos.x, pos.y = x, y
+	} else {
+		// anywhere you can stand will do
+	outer:
+		for y, row := range room {
+			for x := range row {
+				if m.standable(Position{world: pos.world, x: x, y: y}) {
+					pos.x, pos.y = x, y
+					break outer
+				}
+			}
+		}
+	}
+	if !m.standable(pos) {
+		return "", fmt.Errorf("Can't stand at %d,%d in %s", pos.x, pos.y, pos.world)
+	}
+	m.pos = pos
+	m.roomStart = pos
+	m.enterRoom()
+	m.send(moveMsg{
+		id:  m.id,
+		pos: m.pos,
+	})
+	return fmt.Sprintf("Teleported to %s at %d,%d", pos.world, pos.x, pos.y), nil
+}
+
+// matchName finds the one name that is query, or failing that starts with it
+func matchName(query string, names []string) (string, bool) {
+	query = strings.ToLower(query)
+	found := []string{}
+	for _, name := range names {
+		if strings.ToLower(name) == query {
+			return name, true
+		}
+		if strings.HasPrefix(strings.ToLower(name), query) {
+			found = append(found, name)
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	return found[0], true
+}
+
+func (m *model) cmdItem(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["item"].usage)
+	}
+	count := 1
+	if n, err := strconv.Atoi(args[len(args)-1]); err == nil && len(args) > 1 {
+		if n < 1 || n > 99 {
+			return "", fmt.Errorf("Count has to be from 1 to 99")
+		}
+		count = n
+		args = args[:len(args)-1]
+	}
+	query := strings.Join(args, " ")
+	var def *ItemDef
+	if id, err := strconv.Atoi(query); err == nil {
+		def = m.app.items[id]
+	} else {
+		names := []string{}
+		byName := map[string]*ItemDef{}
+		for _, it := range m.app.items {
+			names = append(names, it.Name)
+			byName[it.Name] = it
+		}
+		if name, ok := matchName(query, names); ok {
+			def = byName[name]
+		}
+	}
+	if def == nil {
+		return "", fmt.Errorf("No item %q", query)
+	}
+	if def.Category == CATEGORY_SCROLL {
+		// scrolls get read on the spot
+		return m.learn(def.Spell), nil
+	}
+	for i := 0; i < count; i++ {
+		m.inventory.AddItem(def)
+	}
+	return fmt.Sprintf("Spawned %d %s", count, def.Name), nil
+}
+
+func (m *model) cmdEnemy(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["enemy"].usage)
+	}
+	if m.state != OVERWORLD || m.falling || m.dead {
+		return "", fmt.Errorf("Not right now")
+	}
+	query := strings.Join(args, " ")
+	var def *EnemyDef
+	if id, err := strconv.Atoi(query); err == nil {
+		if id >= 0 && id < 256 {
+			def = m.app.bestiary[byte(id)]
+		}
+	} else {
+		names := []string{}
+		byName := map[string]*EnemyDef{}
+		for _, e := range m.app.bestiary {
+			// "the minotaur" should come up for "minotaur" too
+			names = append(names, e.Name)
+			byName[e.Name] = e
+			if short := strings.TrimPrefix(strings.TrimPrefix(e.Name, "a "), "the "); short != e.Name {
+				names = append(names, short)
+				byName[short] = e
+			}
+		}
+		if name, ok := matchName(query, names); ok {
+			def = byName[name]
+		}
+	}
+	if def == nil {
+		return "", fmt.Errorf("No enemy %q", query)
+	}
+	// the fight happens right where you're standing, and fleeing leaves you there
+	m.prev = m.pos
+	m.enterFight(m.pos, byte(def.ID))
+	return fmt.Sprintf("Spawned %s", def.Name), nil
+}
+
+func (m *model) cmdLevel(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["level"].usage)
+	}
+	level, err := strconv.Atoi(args[0])
+	if err != nil || level < 1 || level > 99 {
+		return "", fmt.Errorf("Level has to be from 1 to 99")
+	}
+	// going up rolls health like a real level up; going down keeps it
+	for m.level < level {
+		m.level++
+		rolledHealth := m.dice.Roll(m.class().hitDie)
+		m.maxHealth += rolledHealth
+		m.health += rolledHealth
+	}
+	m.level = level
+	m.xp = m.xpCurve(m.level)
+	m.updateXpPercent()
+	m.send(levelMsg{
+		id:    m.id,
+		level: m.level,
+	})
+	return fmt.Sprintf("Level %d", m.level), nil
+}
+
+func (m *model) cmdHeal(args []string) (string, error) {
+	m.health = m.maxHealth
+	m.statuses = nil
+	return "Healed", nil
+}
+
+func (m *model) cmdKick(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["kick"].usage)
+	}
+	id, err := m.app.findPlayer(args[0])
+	if err != nil {
+		return "", err
+	}
+	m.app.sendTo(id, kickMsg{})
+	return fmt.Sprintf("Kicked %s", m.app.playerName(id)), nil
+}
+
+// sanction is who a player is and what's being done to them, for the list
+func (m *model) sanction(id string, args []string) (string, *Sanction) {
+	m.app.StateMutex.RLock()
+	defer m.app.StateMutex.RUnlock()
+	return m.app.BanKeys[id], &Sanction{
+		Name:   m.app.Characters[id].Name,
+		Reason: strings.Join(args, " "),
+		By:     m.character.Name,
+		When:   time.Now(),
+	}
+}
+
+// mutes stick to the player's key, so they're still muted next time
+func (m *model) cmdMute(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["mute"].usage)
+	}
+	id, err := m.app.findPlayer(args[0])
+	if err != nil {
+		return "", err
+	}
+	key, s := m.sanction(id, args[1:])
+	if err := m.app.moderation.SetMuted(key, s); err != nil {
+		return "", err
+	}
+	m.send(muteMsg{id: id, muted: true})
+	return fmt.Sprintf("Muted %s", m.app.playerName(id)), nil
+}
+
+// unmute works on whoever's on, or failing that anybody on the list
+func (m *model) cmdUnmute(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["unmute"].usage)
+	}
+	id, err := m.app.findPlayer(args[0])
+	if err != nil {
+		key, err := m.app.moderation.FindMuted(args[0])
+		if err != nil {
+			return "", err
+		}
+		if err := m.app.moderation.SetMuted(key, nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Unmuted %.12s", key), nil
+	}
+	key, _ := m.sanction(id, nil)
+	if err := m.app.moderation.SetMuted(key, nil); err != nil {
+		return "", err
+	}
+	m.send(muteMsg{id: id, muted: false})
+	return fmt.Sprintf("Unmuted %s", m.app.playerName(id)), nil
+}
+
+func (m *model) cmdBan(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["ban"].usage)
+	}
+	id, err := m.app.findPlayer(args[0])
+	if err != nil {
+		return "", err
+	}
+	if id == m.id {
+		return "", fmt.Errorf("You can't ban yourself")
+	}
+	key, s := m.sanction(id, args[1:])
+	if m.app.admins[key] {
+		return "", fmt.Errorf("Take %s out of %s first", m.app.playerName(id), adminsPath)
+	}
+	if err := m.app.moderation.Ban(key, s); err != nil {
+		return "", err
+	}
+	m.app.sendTo(id, kickMsg{})
+	return fmt.Sprintf("Banned %s", m.app.playerName(id)), nil
+}
+
+func (m *model) cmdUnban(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: %s", adminCommands["unban"].usage)
+	}
+	s, err := m.app.moderation.Unban(args[0])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Unbanned %s", s.Name), nil
+}
+
+func (m *model) cmdBans(args []string) (string, error) {
+	bans := m.app.moderation.Bans()
+	sort.Strings(bans)
+	return fmt.Sprintf("%d banned: %s", len(bans), strings.Join(bans, ", ")), nil
+}
+
+func (m *model) cmdReload(args []string) (string, error) {
+	// reloading needs the world to itself, and we're holding it
+	go m.app.reloadLevels()
+	return "Reloading", nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rooms can also be drawn as text in map/NAME.map. The file starts with an
+// optional legend, one glyph per line, ended by a line of "---":
+//
+//	b enemy 0
+//	P item 0
+//	N npc 1
+//	D gate 5
+//	---
+//
+// followed by 16 rows of 40 glyphs. Glyphs the legend doesn't mention fall
+// back to defaultLegend. map/examples/shrine.map is a whole room to start
+// from; nothing in map/examples gets loaded. A room can be drawn one way or
+// the other, not both.
+
+var defaultLegend = map[rune]Color{
+	' ': {},
+	'.': {},
+	'#': {a: 255},
+	'"': {a: 50},
+	'+': {a: 100},
+	'@': {a: 150},
+	'~': {a: 200},
+	'^': {a: 220},
+	'!': {b: 255, a: 255},
+	'?': {r: 255, g: 255, a: 255},
+	'O': {r: 255, b: 255, a: 255},
+	'H': {g: 255, b: 255, a: 255},
+}
+
+// legendColor turns a legend entry into the same color aseprite would use
+func legendColor(kind string, args []string) (Color, error) {
+	arg := func(max int) (byte, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s needs exactly one number", kind)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", kind, err)
+		}
+		if n < 0 || n > max {
+			return 0, fmt.Errorf("%s %d is out of range 0-%d", kind, n, max)
+		}
+		return byte(n), nil
+	}
+	switch kind {
+	case "floor":
+		return Color{}, nil
+	case "wall":
+		return Color{a: 255}, nil
+	case "grass":
+		return Color{a: 50}, nil
+	case "fence":
+		return Color{a: 100}, nil
+	case "carpet":
+		return Color{a: 150}, nil
+	case "swamp":
+		return Color{a: 200}, nil
+	case "embers":
+		return Color{a: 220}, nil
+	case "spawn":
+		return Color{b: 255, a: 255}, nil
+	case "secret":
+		return Color{r: 255, g: 255, a: 255}, nil
+	case "hole":
+		return Color{r: 255, b: 255, a: 255}, nil
+	case "heal":
+		return Color{g: 255, b: 255, a: 255}, nil
+	case "enemy":
+		id, err := arg(254)
+		return Color{r: 255 - id, a: 255}, err
+	case "item":
+		id, err := arg(254)
+		return Color{g: 255 - id, a: 255}, err
+	case "npc":
+		id, err := arg(253)
+		return Color{b: 254 - id, a: 255}, err
+	case "gate":
+		level, err := arg(54)
+		if err == nil && level == 0 {
+			err = fmt.Errorf("gate level must be at least 1")
+		}
+		return Color{g: 255 - level, b: 255, a: 255}, err
+	}
+	return Color{}, fmt.Errorf("unknown tile kind %q", kind)
+}
+
+func parseAsciiLevel(r io.Reader) ([16][40]Color, error) {
+	tmp := [16][40]Color{}
+	legend := map[rune]Color{}
+	for k, v := range defaultLegend {
+		legend[k] = v
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return tmp, err
+	}
+
+	rows := lines
+	for i, line := range lines {
+		if line != "---" {
+			continue
+		}
+		for n, entry := range lines[:i] {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			glyph, size := utf8.DecodeRuneInString(entry)
+			fields := strings.Fields(entry[size:])
+			if len(fields) == 0 {
+				return tmp, fmt.Errorf("legend line %d: missing tile kind", n+1)
+			}
+			c, err := legendColor(fields[0], fields[1:])
+			if err != nil {
+				return tmp, fmt.Errorf("legend line %d: %w", n+1, err)
+			}
+			legend[glyph] = c
+		}
+		rows = lines[i+1:]
+		break
+	}
+
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+	if len(rows) != len(tmp) {
+		return tmp, fmt.Errorf("room has %d rows, want %d", len(rows), len(tmp))
+	}
+	for y, row := range rows {
+		if utf8.RuneCountInString(row) != len(tmp[y]) {
+			return tmp, fmt.Errorf("row %d has %d columns, want %d", y, utf8.RuneCountInString(row), len(tmp[y]))
+		}
+		x := 0
+		for _, glyph := range row {
+			c, ok := legend[glyph]
+			if !ok {
+				return tmp, fmt.Errorf("unknown glyph %q at %dx%d", glyph, x, y)
+			}
+			tmp[y][x] = c
+			x++
+		}
+	}
+	return tmp, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// asciiRoom is a room of floor with a wall along the top, and whatever's in
+// cells drawn over it
+func asciiRoom(legend string, cells map[[2]int]rune) string {
+	rows := [][]rune{}
+	for y := 0; y < 16; y++ {
+		fill := "."
+		if y == 0 {
+			fill = "#"
+		}
+		rows = append(rows, []rune(strings.Repeat(fill, 40)))
+	}
+	for at, glyph := range cells {
+		rows[at[1]][at[0]] = glyph
+	}
+	out := legend
+	if legend != "" {
+		out += "---\n"
+	}
+	for _, row := range rows {
+		out += string(row) + "\n"
+	}
+	return out
+}
+
+func TestParseAsciiLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[[2]int]Color // cells to check
+		err   string           // part of the error, if there should be one
+	}{
+		{
+			name:  "default legend",
+			input: asciiRoom("", map[[2]int]rune{{1, 1}: '!', {2, 1}: '?', {3, 1}: 'O', {4, 1}: 'H', {5, 1}: '+', {6, 1}: '"', {7, 1}: '@', {8, 1}: '~', {9, 1}: '^'}),
+			want: map[[2]int]Color{
+				{0, 0}: {a: 255},
+				{0, 1}: {},
+				{1, 1}: {b: 255, a: 255},
+				{2, 1}: {r: 255, g: 255, a: 255},
+				{3, 1}: {r: 255, b: 255, a: 255},
+				{4, 1}: {g: 255, b: 255, a: 255},
+				{5, 1}: {a: 100},
+				{6, 1}: {a: 50},
+				{7, 1}: {a: 150},
+				{8, 1}: {a: 200},
+				{9, 1}: {a: 220},
+			},
+		},
+		{
+			name:  "legend",
+			input: asciiRoom("b enemy 3\nP item 2\nN npc 1\nD gate 5\n\n", map[[2]int]rune{{1, 1}: 'b', {2, 1}: 'P', {3, 1}: 'N', {4, 1}: 'D'}),
+			want: map[[2]int]Color{
+				{1, 1}: {r: 252, a: 255},
+				{2, 1}: {g: 253, a: 255},
+				{3, 1}: {b: 253, a: 255},
+				{4, 1}: {g: 250, b: 255, a: 255},
+			},
+		},
+		{
+			name:  "legend overrides the defaults",
+			input: asciiRoom("# floor\n", nil),
+			want:  map[[2]int]Color{{0, 0}: {}},
+		},
+		{
+			name:  "windows line endings",
+			input: strings.ReplaceAll(asciiRoom("b enemy 1\n", map[[2]int]rune{{39, 15}: 'b'}), "\n", "\r\n"),
+			want:  map[[2]int]Color{{39, 15}: {r: 254, a: 255}},
+		},
+		{
+			name:  "too few rows",
+			input: strings.Repeat(strings.Repeat(".", 40)+"\n", 15),
+			err:   "room has 15 rows, want 16",
+		},
+		{
+			name:  "too many columns",
+			input: strings.Replace(asciiRoom("", nil), "\n", "..\n", 1),
+			err:   "row 0 has 42 columns, want 40",
+		},
+		{
+			name:  "unknown glyph",
+			input: asciiRoom("", map[[2]int]rune{{3, 4}: 'z'}),
+			err:   `unknown glyph 'z' at 3x4`,
+		},
+		{
+			name:  "unknown kind",
+			input: asciiRoom("z lava\n", nil),
+			err:   `legend line 1: unknown tile kind "lava"`,
+		},
+		{
+			name:  "missing kind",
+			input: asciiRoom("b enemy 1\nz\n", nil),
+			err:   "legend line 2: missing tile kind",
+		},
+		{
+			name:  "missing id",
+			input: asciiRoom("b enemy\n", nil),
+			err:   "enemy needs exactly one number",
+		},
+		{
+			name:  "id out of range",
+			input: asciiRoom("N npc 254\n", nil),
+			err:   "npc 254 is out of range 0-253",
+		},
+		{
+			name:  "gate level zero",
+			input: asciiRoom("D gate 0\n", nil),
+			err:   "gate level must be at least 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room, err := parseAsciiLevel(strings.NewReader(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for at, want := range tt.want {
+				if got := room[at[1]][at[0]]; got != want {
+					t.Errorf("%dx%d is %+v, want %+v", at[0], at[1], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExampleRooms(t *testing.T) {
+	paths, err := filepath.Glob("map/examples/*.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no example rooms")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if _, err := parseAsciiLevel(file); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
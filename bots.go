@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Bots are headless players. Each one drives model.Update the same way a
+// tea.Program would, walking around, fighting, chatting and reconnecting,
+// and checks after every message that the game still makes sense.
+
+// how long to wait for everything to wind down once the bots are done
+const botSettle = 10 * time.Second
+
+// session is one connection's worth of mailbox. Anything sent after it's
+// closed is dropped, same as a tea.Program that has exited.
+type session struct {
+	inbox chan tea.Msg
+	quit  chan struct{}
+}
+
+func (s *session) Send(msg tea.Msg) {
+	select {
+	case s.inbox <- msg:
+	case <-s.quit:
+	}
+}
+
+type botReport struct {
+	mutex    sync.Mutex
+	problems []string
+	seen     map[string]bool
+	moves    int
+	fights   int
+	deaths   int
+	chats    int
+	rejoins  int
+}
+
+func (r *botReport) fail(who string, format string, args ...any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// a bot stuck in a bad state would otherwise report it every step
+	problem := who + ": " + fmt.Sprintf(format, args...)
+	if r.seen[problem] {
+		return
+	}
+	r.seen[problem] = true
+	r.problems = append(r.problems, problem)
+}
+
+func (r *botReport) count(n *int) {
+	r.mutex.Lock()
+	*n++
+	r.mutex.Unlock()
+}
+
+type bot struct {
+	name    string
+	app     *app
+	report  *botReport
+	m       model
+	session *session
+	joins   int
+	quit    bool
+	spread  bool // start somewhere random instead of at spawn
+	rng     *rand.Rand
+	heading string
+	pending sync.WaitGroup
+}
+
+func (b *bot) connect() {
+	b.joins++
+	b.session = &session{inbox: make(chan tea.Msg, 256), quit: make(chan struct{})}
+	b.quit = false
+	b.app.WorldMutex.RLock()
+	b.m = b.app.newModel(fmt.Sprintf("%s#%d", b.name, b.joins), "", "bot", 80, 24)
+	b.m.character = Character{
+		Name:  b.name,
+		Color: characterColors[b.rng.Intn(len(characterColors))],
+		Glyph: characterGlyphs[b.rng.Intn(len(characterGlyphs))],
+		Class: classOrder[b.rng.Intn(len(classOrder))],
+	}
+	b.m.maxHealth = classDefs[b.m.character.Class].health
+	b.m.health = b.m.maxHealth
+	// so fights get some spells cast in them too
+	b.m.spells = []string{SPELL_CURE, SPELL_FIREBOLT}
+	if b.spread {
+		b.m.pos = b.somewhere()
+		b.m.roomStart = b.m.pos
+		b.m.enterRoom()
+	}
+	if b.app.recordings != "" {
+		if rec, err := b.app.record(&b.m); err != nil {
+			b.report.fail(b.m.id, "could not record: %v", err)
+		} else {
+			b.m.tape = rec
+		}
+	}
+	b.m.rejoin()
+	b.app.WorldMutex.RUnlock()
+	b.app.publish(registerMsg{id: b.m.id, p: b.session, pos: b.m.pos, level: b.m.level})
+}
+
+// somewhere picks a random spot a player could stand on
+func (b *bot) somewhere() Position {
+	worlds := []string{}
+	for world := range b.app.world {
+		worlds = append(worlds, world)
+	}
+	for {
+		pos := Position{world: worlds[b.rng.Intn(len(worlds))], x: b.rng.Intn(40), y: b.rng.Intn(16)}
+		cell := b.app.world[pos.world][pos.y][pos.x]
+		if b.m.standable(pos) && !cell.isHole() && !cell.isGate() && !cell.isEnemy() {
+			return pos
+		}
+	}
+}
+
+func (b *bot) disconnect() {
+	if !b.quit {
+		b.update(DisconnectMsg{})
+	}
+	close(b.session.quit)
+	b.m.tape.close()
+}
+
+// update feeds one message through the model, then runs whatever it asked for
+func (b *bot) update(msg tea.Msg) {
+	switch msg := msg.(type) {
+	case tea.BatchMsg:
+		for _, cmd := range msg {
+			b.exec(cmd)
+		}
+		return
+	case tea.QuitMsg:
+		b.quit = true
+		return
+	}
+	wasFighting := b.m.state == IN_COMBAT
+	next, cmd := b.m.Update(msg)
+	b.m = next.(model)
+	if b.app.recordings != "" {
+		// a recording has a frame for every message, like a tea.Program
+		b.m.View()
+	}
+	if !wasFighting && b.m.state == IN_COMBAT {
+		b.report.count(&b.report.fights)
+	}
+	if _, ok := msg.(DeadMsg); ok {
+		b.report.count(&b.report.deaths)
+	}
+	b.check()
+	b.exec(cmd)
+}
+
+func (b *bot) exec(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	s := b.session
+	b.pending.Add(1)
+	go func() {
+		defer b.pending.Done()
+		if msg := cmd(); msg != nil {
+			s.Send(msg)
+		}
+	}()
+}
+
+// check is everything that should always be true about a player
+func (b *bot) check() {
+	m := &b.m
+	b.app.WorldMutex.RLock()
+	defer b.app.WorldMutex.RUnlock()
+	room, ok := b.app.world[m.pos.world]
+	if !ok {
+		b.report.fail(m.id, "in a room that doesn't exist: %q", m.pos.world)
+		return
+	}
+	if m.pos.y < 0 || m.pos.y >= len(room) || m.pos.x < 0 || m.pos.x >= len(room[m.pos.y]) {
+		b.report.fail(m.id, "out of bounds at %dx%d in %s", m.pos.x, m.pos.y, m.pos.world)
+		return
+	}
+	if !m.standable(m.pos) {
+		b.report.fail(m.id, "standing in a wall at %dx%d in %s", m.pos.x, m.pos.y, m.pos.world)
+	}
+	if m.health > m.maxHealth {
+		b.report.fail(m.id, "health %d is over max health %d", m.health, m.maxHealth)
+	}
+	if m.health < 0 {
+		b.report.fail(m.id, "health went negative: %d", m.health)
+	}
+	if (m.state == IN_COMBAT) != (m.fight != nil) {
+		b.report.fail(m.id, "state %d doesn't match fight %v", m.state, m.fight != nil)
+	}
+}
+
+// drain handles everything that's arrived since last time
+func (b *bot) drain() {
+	for {
+		select {
+		case msg := <-b.session.inbox:
+			b.update(msg)
+		default:
+			return
+		}
+	}
+}
+
+func keyMsg(k string) tea.KeyMsg {
+	switch k {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	case "tab":
+		return tea.KeyMsg{Type: tea.KeyTab}
+	case "up":
+		return tea.KeyMsg{Type: tea.KeyUp}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	case "left":
+		return tea.KeyMsg{Type: tea.KeyLeft}
+	case "right":
+		return tea.KeyMsg{Type: tea.KeyRight}
+	case "ctrl+c":
+		return tea.KeyMsg{Type: tea.KeyCtrlC}
+	case "ctrl+u":
+		return tea.KeyMsg{Type: tea.KeyCtrlU}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+}
+
+func (b *bot) press(keys ...string) {
+	for _, k := range keys {
+		b.update(keyMsg(k))
+	}
+}
+
+func (b *bot) say(text string) {
+	b.press("t", text, "enter")
+	b.report.count(&b.report.chats)
+}
+
+// act does one random thing that makes sense right now
+func (b *bot) act() {
+	m := &b.m
+	if m.falling || m.dead {
+		return
+	}
+	switch m.state {
+	case CREATING:
+		// our name's still held by the last time we were on
+		b.press("ctrl+u", fmt.Sprintf("%s_%d", b.name, b.joins), "enter")
+	case OVERWORLD:
+		switch n := b.rng.Intn(100); {
+		case n < 2:
+			// mostly room chat, with the other channels now and then
+			b.say([]string{"", "", "/g ", "/p ", "/me "}[b.rng.Intn(5)] + fmt.Sprintf("hi from %s", b.name))
+		case n < 4:
+			b.press("f")
+		case n < 5:
+			b.press("i")
+		default:
+			// keep going the same way for a while so bots actually get somewhere
+			if b.heading == "" || b.rng.Intn(4) == 0 {
+				b.heading = []string{"w", "a", "s", "d"}[b.rng.Intn(4)]
+			}
+			b.press(b.heading)
+			b.report.count(&b.report.moves)
+		}
+	case IN_INVENTORY:
+		b.press([]string{"down", "enter", "esc"}[b.rng.Intn(3)])
+	case IN_NPC:
+		b.press("enter")
+	case IN_COMBAT:
+		if !m.canPick() {
+			return
+		}
+		// mostly fight, sometimes try something else
+		switch b.rng.Intn(10) {
+		case 0:
+			b.press("down")
+		case 1:
+			// the class ability is at the bottom
+			b.press("up")
+		}
+		b.press("enter")
+	}
+}
+
+// runScript plays back one command per line: a key to press, "say <text>",
+// "wait <duration>" or "disconnect". Blank lines and # comments are skipped.
+func (b *bot) runScript(lines []string, every time.Duration) {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b.drain()
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "say":
+			b.say(arg)
+		case "wait":
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				b.report.fail(b.name, "bad wait %q", arg)
+				return
+			}
+			b.sleep(d)
+		case "disconnect":
+			b.disconnect()
+			b.report.count(&b.report.rejoins)
+			b.connect()
+		default:
+			b.press(line)
+		}
+		if b.quit {
+			return
+		}
+		b.view()
+		b.sleep(every)
+	}
+}
+
+// runRandom takes a number of random steps, now and then dropping the
+// connection and coming back as somebody new
+func (b *bot) runRandom(steps int, every time.Duration) {
+	for i := 0; i < steps && !b.quit; i++ {
+		b.drain()
+		if b.rng.Intn(300) == 0 {
+			b.disconnect()
+			b.report.count(&b.report.rejoins)
+			b.connect()
+			continue
+		}
+		b.act()
+		b.view()
+		b.sleep(every)
+	}
+}
+
+// view draws once a step, since drawing is slow but it still has to not
+// panic. A recording already drew after every message.
+func (b *bot) view() {
+	if b.app.recordings == "" {
+		b.m.View()
+	}
+}
+
+// sleep waits while still handling messages, like a real program would
+func (b *bot) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-b.session.inbox:
+			b.update(msg)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func runBots(args []string) int {
+	flags := flag.NewFlagSet("bots", flag.ExitOnError)
+	count := flags.Int("n", 20, "how many bots to run at once")
+	steps := flags.Int("steps", 500, "how many random steps each bot takes")
+	every := flags.Duration("every", 20*time.Millisecond, "how long each bot waits between steps")
+	script := flags.String("script", "", "play this script instead of walking around at random")
+	spread := flags.Bool("spread", true, "start bots all over the map instead of at spawn")
+	seed := flags.Int64("seed", newSeed(), "seed for the dice and for what the bots decide to do")
+	record := flags.String("record", "", "record every bot's session into this directory")
+	flags.Parse(args)
+
+	var lines []string
+	if *script != "" {
+		file, err := os.Open(*script)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+
+	a := newApp()
+	a.dice = NewDice(*seed)
+	if err := a.loadLevels(); err != nil {
+		fmt.Println("load error:", err)
+		return 1
+	}
+	if *record != "" {
+		if err := os.MkdirAll(*record, 0o755); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		a.recordings = *record
+	}
+	go a.runHub()
+	go a.watchMobs()
+	// give the hub a moment so it's counted as part of the baseline
+	time.Sleep(10 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	report := &botReport{seen: map[string]bool{}}
+	bots := []*bot{}
+	var wg sync.WaitGroup
+	for i := 0; i < *count; i++ {
+		b := &bot{
+			name:   fmt.Sprintf("bot%d", i),
+			app:    a,
+			report: report,
+			spread: *spread,
+			rng:    rand.New(rand.NewSource(*seed + int64(i))),
+		}
+		bots = append(bots, b)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					report.fail(b.name, "panic: %v\n%s", r, debug.Stack())
+				}
+			}()
+			b.connect()
+			if *script != "" {
+				b.runScript(lines, *every)
+			} else {
+				b.runRandom(*steps, *every)
+			}
+			b.disconnect()
+		}()
+	}
+	wg.Wait()
+
+	// everybody's gone, so the server should be back to how it started
+	deadline := time.Now().Add(botSettle)
+	for _, b := range bots {
+		b.pending.Wait()
+	}
+	for time.Now().Before(deadline) && !settled(a, baseline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	a.StateMutex.RLock()
+	if len(a.Positions) > 0 {
+		report.fail("server", "%d players still on the map", len(a.Positions))
+	}
+	a.StateMutex.RUnlock()
+	a.ProgramsMutex.RLock()
+	if len(a.programs) > 0 {
+		report.fail("server", "%d programs still registered", len(a.programs))
+	}
+	a.ProgramsMutex.RUnlock()
+	a.FightsMutex.Lock()
+	if len(a.fights) > 0 {
+		report.fail("server", "%d fights still going", len(a.fights))
+	}
+	a.FightsMutex.Unlock()
+	if n := runtime.NumGoroutine(); n > baseline {
+		report.fail("server", "%d goroutines leaked", n-baseline)
+		pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
+	}
+
+	fmt.Printf("seed %d\n", *seed)
+	fmt.Printf("%d bots: %d moves, %d fights, %d deaths, %d chats, %d reconnects\n",
+		len(bots), report.moves, report.fights, report.deaths, report.chats, report.rejoins)
+	for _, p := range report.problems {
+		fmt.Println(p)
+	}
+	if len(report.problems) > 0 {
+		fmt.Printf("\n%d problems found\n", len(report.problems))
+		return 1
+	}
+	fmt.Println("no problems found")
+	return 0
+}
+
+// settled is whether the server has forgotten about every bot
+func settled(a *app, baseline int) bool {
+	a.StateMutex.RLock()
+	players := len(a.Positions)
+	a.StateMutex.RUnlock()
+	a.FightsMutex.Lock()
+	fights := len(a.fights)
+	a.FightsMutex.Unlock()
+	return players == 0 && fights == 0 && runtime.NumGoroutine() <= baseline
+}
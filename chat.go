@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Chat goes through the hub, which cleans it up and works out who hears it:
+// everybody, the room, your party, or just whoever you whispered to. Room
+// chat still pops up as a bubble over your head too. Everything anyone hears
+// lands in their scrollback, and anything starting with / is a command.
+
+// Channels
+const (
+	CHANNEL_ROOM    = "room"
+	CHANNEL_GLOBAL  = "global"
+	CHANNEL_PARTY   = "party"
+	CHANNEL_WHISPER = "whisper"
+	CHANNEL_SYSTEM  = "system" // just for you, from the game
+)
+
+const (
+	chatScrollback = 100 // lines kept per player
+	chatHistory    = 20  // things you said that up and down bring back
+	chatPane       = 4   // lines shown at once
+	bubbleTime     = 2 * time.Second
+)
+
+// ChatLine is one line of somebody's scrollback
+type ChatLine struct {
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+	Emote   bool   `json:"emote,omitempty"`
+}
+
+type chatLineMsg struct {
+	line  ChatLine
+	yours bool // you said it
+}
+
+// whoMsg asks the hub who's on
+type whoMsg struct {
+	id string
+}
+
+var chatHelp = "/g /r /p [text] switch channel or talk on one, /w name text, /me does, /who, /report name why"
+
+// routeChat hands a message to everybody who should hear it. It runs on
+// the hub.
+func (a *app) routeChat(msg ChatMsg, at time.Time) {
+	ids := []string{}
+	a.StateMutex.Lock()
+	pos, ok := a.Positions[msg.id]
+	bubble := ok && msg.channel == CHANNEL_ROOM && !msg.emote
+	if bubble {
+		a.Chats[msg.id] = msg.msg
+	}
+	name := a.Characters[msg.id].Name
+	toName := ""
+	for id, p := range a.Positions {
+		switch msg.channel {
+		case CHANNEL_GLOBAL, CHANNEL_PARTY:
+			ids = append(ids, id)
+		case CHANNEL_ROOM:
+			if p.world == pos.world {
+				ids = append(ids, id)
+			}
+		case CHANNEL_WHISPER:
+			if strings.EqualFold(a.Characters[id].Name, msg.to) {
+				ids = append(ids, id)
+				toName = a.Characters[id].Name
+			}
+		}
+	}
+	a.StateMutex.Unlock()
+	if !ok {
+		// they already left
+		return
+	}
+	if bubble {
+		a.rerender(pos.world, msg.id, at)
+	}
+	if msg.msg == "" {
+		// just taking a bubble down
+		return
+	}
+
+	line := ChatLine{From: name, To: msg.to, Channel: msg.channel, Text: msg.msg, Emote: msg.emote}
+	switch msg.channel {
+	case CHANNEL_PARTY:
+		a.worldState.mutex.Lock()
+		party := a.worldState.party(msg.id)
+		inParty := []string{}
+		for _, id := range ids {
+			if a.worldState.party(id) == party {
+				inParty = append(inParty, id)
+			}
+		}
+		a.worldState.mutex.Unlock()
+		if len(inParty) < 2 {
+			a.sendTo(msg.id, chatLineMsg{line: systemLine("You're not in a party. Fight alongside somebody first.")})
+			return
+		}
+		ids = inParty
+	case CHANNEL_WHISPER:
+		if len(ids) == 0 {
+			a.sendTo(msg.id, chatLineMsg{line: systemLine(fmt.Sprintf("Nobody called %s is here.", msg.to))})
+			return
+		}
+		line.To = toName
+		// you see what you whispered too
+		if !(len(ids) == 1 && ids[0] == msg.id) {
+			a.sendTo(msg.id, chatLineMsg{line: line, yours: true})
+		}
+	}
+	for _, id := range ids {
+		a.sendTo(id, chatLineMsg{line: line, yours: id == msg.id})
+	}
+}
+
+func systemLine(text string) ChatLine {
+	return ChatLine{Channel: CHANNEL_SYSTEM, Text: text}
+}
+
+// sendChat works out what the player typed and sends it
+func (m *model) sendChat(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	m.sent = append(m.sent, text)
+	if len(m.sent) > chatHistory {
+		m.sent = m.sent[len(m.sent)-chatHistory:]
+	}
+	m.recall = len(m.sent)
+	if !strings.HasPrefix(text, "/") {
+		m.say(m.channel, text, false)
+		return
+	}
+	command, rest, _ := strings.Cut(text[1:], " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(command) {
+	case "g", "global":
+		m.sayOn(CHANNEL_GLOBAL, rest)
+	case "r", "room":
+		m.sayOn(CHANNEL_ROOM, rest)
+	case "p", "party":
+		m.sayOn(CHANNEL_PARTY, rest)
+	case "me":
+		if rest == "" {
+			m.addLine(systemLine("/me waves"))
+			return
+		}
+		m.say(m.channel, rest, true)
+	case "w", "whisper":
+		to, what, _ := strings.Cut(rest, " ")
+		what = strings.TrimSpace(what)
+		if to == "" || what == "" {
+			m.addLine(systemLine("/w name what to say"))
+			return
+		}
+		m.send(ChatMsg{id: m.id, channel: CHANNEL_WHISPER, to: to, msg: what})
+	case "report":
+		name, why, _ := strings.Cut(rest, " ")
+		why = strings.TrimSpace(why)
+		if name == "" || why == "" {
+			m.addLine(systemLine("/report name what they did"))
+			return
+		}
+		m.send(reportMsg{id: m.id, name: name, reason: why})
+	case "who":
+		m.send(whoMsg{id: m.id})
+	case "help", "?":
+		m.addLine(systemLine(chatHelp))
+	default:
+		m.addLine(systemLine(fmt.Sprintf("No /%s. %s", command, chatHelp)))
+	}
+}
+
+// sayOn talks on a channel, or just switches to it if there's nothing to say
+func (m *model) sayOn(channel string, text string) {
+	if text == "" {
+		m.channel = channel
+		m.addLine(systemLine(fmt.Sprintf("Now talking to %s.", channelName(channel))))
+		return
+	}
+	m.say(channel, text, false)
+}
+
+func (m *model) say(channel string, text string, emote bool) {
+	m.send(ChatMsg{
+		id:      m.id,
+		channel: channel,
+		msg:     text,
+		emote:   emote,
+	})
+}
+
+// heard puts a line in the scrollback. Your own room chat comes back cleaned
+// up, and that's what goes in your bubble.
+func (m *model) heard(msg chatLineMsg) tea.Cmd {
+	m.addLine(msg.line)
+	if !msg.yours || msg.line.Channel != CHANNEL_ROOM || msg.line.Emote {
+		return nil
+	}
+	// the bubble over your head goes away by itself
+	text := msg.line.Text
+	m.chattext = text
+	return func() tea.Msg {
+		time.Sleep(bubbleTime)
+		return ChatClearMsg{
+			msg: text,
+		}
+	}
+}
+
+// who is everybody that's on. It runs on the hub, so the answer comes back
+// as a chat line like anything else.
+func (a *app) who(asking string) ChatLine {
+	a.StateMutex.RLock()
+	defer a.StateMutex.RUnlock()
+	here := a.Positions[asking].world
+	who := []string{}
+	making := 0
+	for id, pos := range a.Positions {
+		c, ok := a.Characters[id]
+		switch {
+		case !ok:
+			making++
+		case pos.world == here:
+			who = append(who, fmt.Sprintf("%s (%d, here)", c.Name, a.Levels[id]))
+		default:
+			who = append(who, fmt.Sprintf("%s (%d)", c.Name, a.Levels[id]))
+		}
+	}
+	sort.Strings(who)
+	line := fmt.Sprintf("%d online: %s", len(who), strings.Join(who, ", "))
+	if making > 0 {
+		line += fmt.Sprintf(", and %d still making a character", making)
+	}
+	return systemLine(line)
+}
+
+func channelName(channel string) string {
+	switch channel {
+	case CHANNEL_GLOBAL:
+		return "everyone"
+	case CHANNEL_PARTY:
+		return "your party"
+	}
+	return "the room"
+}
+
+// addLine puts a line in the scrollback, keeping the view still if the
+// player has scrolled up
+func (m *model) addLine(line ChatLine) {
+	m.chatLog = append(m.chatLog, line)
+	if len(m.chatLog) > chatScrollback {
+		m.chatLog = m.chatLog[len(m.chatLog)-chatScrollback:]
+	}
+	if m.chatScroll > 0 && m.chatScroll < len(m.chatLog)-chatPane {
+		m.chatScroll++
+	}
+}
+
+// updateChat handles keys while the chat box is open
+func (m *model) updateChat(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		text := m.chat.Value()
+		m.chat.Blur()
+		m.chat.SetValue("")
+		m.sendChat(text)
+		return nil
+	case "esc":
+		m.chat.Blur()
+		m.chat.SetValue("")
+		return nil
+	case "up", "down":
+		if msg.String() == "up" && m.recall > 0 {
+			m.recall--
+		} else if msg.String() == "down" && m.recall < len(m.sent) {
+			m.recall++
+		}
+		if m.recall < len(m.sent) {
+			m.chat.SetValue(m.sent[m.recall])
+		} else {
+			m.chat.SetValue("")
+		}
+		m.chat.CursorEnd()
+		return nil
+	case "pgup":
+		m.chatScroll += chatPane
+		if top := len(m.chatLog) - chatPane; m.chatScroll > top {
+			m.chatScroll = top
+		}
+		if m.chatScroll < 0 {
+			m.chatScroll = 0
+		}
+		return nil
+	case "pgdown":
+		m.chatScroll -= chatPane
+		if m.chatScroll < 0 {
+			m.chatScroll = 0
+		}
+		return nil
+	}
+	var cmd tea.Cmd
+	m.chat, cmd = m.chat.Update(msg)
+	return cmd
+}
+
+func (line ChatLine) render() string {
+	text := line.Text
+	switch {
+	case line.Channel == CHANNEL_SYSTEM:
+		return gray(text)
+	case line.Emote:
+		text = fmt.Sprintf("* %s %s", line.From, text)
+	case line.Channel == CHANNEL_WHISPER:
+		return cyan(fmt.Sprintf("%s > %s: %s", line.From, line.To, text))
+	default:
+		text = fmt.Sprintf("%s: %s", line.From, text)
+	}
+	switch line.Channel {
+	case CHANNEL_GLOBAL:
+		return yellow("[all] " + text)
+	case CHANNEL_PARTY:
+		return green("[party] " + text)
+	}
+	return text
+}
+
+// chatView is the scrollback and the box you type into
+func (m *model) chatView() string {
+	end := len(m.chatLog) - m.chatScroll
+	start := end - chatPane
+	if start < 0 {
+		start = 0
+	}
+	lines := []string{}
+	for _, line := range m.chatLog[start:end] {
+		lines = append(lines, " "+line.render())
+	}
+	for len(lines) < chatPane {
+		lines = append([]string{""}, lines...)
+	}
+	if m.chatScroll > 0 {
+		lines[0] = gray(fmt.Sprintf(" (%d more below, pgdown)", m.chatScroll))
+	}
+	return strings.Join(lines, "\n") + "\n" + m.chat.View()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Everybody picks a class when they make their character. It decides how
+// much health they start with and get each level, how well they hit, and
+// what else they can do in a fight besides swing their weapon.
+
+// Classes
+const (
+	CLASS_FIGHTER = "fighter"
+	CLASS_ROGUE   = "rogue"
+	CLASS_CLERIC  = "cleric"
+)
+
+// the order they come up on the creation screen
+var classOrder = []string{CLASS_FIGHTER, CLASS_ROGUE, CLASS_CLERIC}
+
+type ClassDef struct {
+	about        string
+	health       int      // what you start with
+	hitDie       DiceExpr // max health you get each level
+	attack       int      // added to attack rolls
+	mana         int      // what you start with
+	manaPerLevel int
+	ability      string // what shows up in the picker
+	uses         int    // how many times a fight you can use it
+}
+
+var classDefs = map[string]ClassDef{
+	CLASS_FIGHTER: {
+		about:        "tough, gets a second wind",
+		health:       10,
+		hitDie:       mustDice("1d10"),
+		attack:       5,
+		mana:         2,
+		manaPerLevel: 1,
+		ability:      "second wind",
+		uses:         1,
+	},
+	CLASS_ROGUE: {
+		about:        "sneak attacks hit hard",
+		health:       7,
+		hitDie:       mustDice("1d6+1"),
+		attack:       4,
+		mana:         4,
+		manaPerLevel: 1,
+		ability:      "sneak attack",
+		uses:         2,
+	},
+	CLASS_CLERIC: {
+		about:        "heals anyone in the fight",
+		health:       8,
+		hitDie:       mustDice("1d8"),
+		attack:       3,
+		mana:         6,
+		manaPerLevel: 2,
+		ability:      "mend",
+		uses:         2,
+	},
+}
+
+var (
+	secondWind  = mustDice("1d10")
+	sneakDamage = mustDice("2d6")
+	mendHealing = mustDice("1d8")
+)
+
+// class is what the player picked, or a fighter if they somehow didn't
+func (m *model) class() ClassDef {
+	if def, ok := classDefs[m.character.Class]; ok {
+		return def
+	}
+	return classDefs[CLASS_FIGHTER]
+}
+
+// AbilityMsg is a player using their class ability, on target if it needs one
+type AbilityMsg struct {
+	target string
+}
+
+// HealedMsg is somebody else in the fight healing this player
+type HealedMsg struct {
+	amount int
+}
+
+func AbilityCmd(target string) tea.Cmd {
+	return func() tea.Msg {
+		return AbilityMsg{target: target}
+	}
+}
+
+// abilityOptions are the picker entries for the player's class ability
+func (m *model) abilityOptions() []PickerItem {
+	def := m.class()
+	left := def.uses - m.abilityUsed
+	if left <= 0 {
+		return []PickerItem{{
+			text:     fmt.Sprintf("%s (0)", def.ability),
+			disabled: true,
+			tooltip:  "Used up until the next fight",
+		}}
+	}
+	if m.character.Class != CLASS_CLERIC {
+		return []PickerItem{{
+			text:    fmt.Sprintf("%s (%d)", def.ability, left),
+			action:  AbilityCmd(""),
+			tooltip: def.about,
+		}}
+	}
+	items := []PickerItem{{
+		text:    fmt.Sprintf("%s yourself (%d)", def.ability, left),
+		action:  AbilityCmd(m.id),
+		tooltip: def.about,
+	}}
+	for _, c := range m.allies {
+		items = append(items, PickerItem{
+			text:    fmt.Sprintf("%s %s (%d)", def.ability, c.name, left),
+			action:  AbilityCmd(c.id),
+			tooltip: def.about,
+		})
+	}
+	return items
+}
+
+// useAbility does whatever the player's class does
+func (m *model) useAbility(target string) {
+	f := m.fight
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.myTurn(m.id) || m.abilityUsed >= m.class().uses {
+		return
+	}
+	m.abilityUsed++
+	switch m.character.Class {
+	case CLASS_ROGUE:
+		m.sneakAttack()
+	case CLASS_CLERIC:
+		m.mend(target)
+	default:
+		healing := m.heal(m.dice.Roll(secondWind.Plus(m.level)))
+		m.updateOptions()
+		f.endTurn(m.id, fmt.Sprintf("You got your second wind and healed for %d!", healing))
+	}
+}
+
+// heal adds health up to the max and says how much it really added
+func (m *model) heal(amount int) int {
+	if amount > m.maxHealth-m.health {
+		amount = m.maxHealth - m.health
+	}
+	m.health += amount
+	return amount
+}
+
+// sneakAttack is a melee attack with extra damage, and advantage when
+// somebody else is keeping the enemy busy. The caller holds the fight's lock.
+func (m *model) sneakAttack() {
+	f := m.fight
+	m.updateOptions()
+	busy := len(f.players()) > 1 || len(f.enemy.statuses) > 0
+	if m.playerAttack(busy) < f.enemy.ac+f.enemy.statuses.ACMod() {
+		f.endTurn(m.id, "The sneak attack missed!")
+		return
+	}
+	dmg := m.playerDamage() + m.dice.Roll(sneakDamage)
+	m.hitEnemy(fmt.Sprintf("You snuck in for %d damage!", dmg), dmg)
+}
+
+// mend heals the player or anybody else in the fight. The caller holds the
+// fight's lock.
+func (m *model) mend(target string) {
+	f := m.fight
+	healing := m.dice.Roll(mendHealing.Plus(m.level))
+	if target == m.id {
+		healing = m.heal(healing)
+		m.updateOptions()
+		f.endTurn(m.id, fmt.Sprintf("You mended yourself for %d!", healing))
+		return
+	}
+	inFight := false
+	for _, id := range f.players() {
+		if id == target {
+			inFight = true
+		}
+	}
+	m.updateOptions()
+	if !inFight {
+		f.endTurn(m.id, "The prayer went unanswered.")
+		return
+	}
+	m.app.sendTo(target, HealedMsg{amount: healing})
+	f.endTurn(m.id, fmt.Sprintf("You mended %s for %d!", f.name(target), healing))
+}
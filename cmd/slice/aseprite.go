@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// just enough of the aseprite format to pull the pixels out of levels.ase
+// https://github.com/aseprite/aseprite/blob/main/docs/ase-file-specs.md
+
+const (
+	aseMagic      = 0xA5E0
+	aseFrameMagic = 0xF1FA
+	chunkCel      = 0x2005
+	celRaw        = 0
+	celCompressed = 2
+)
+
+type aseHeader struct {
+	FileSize   uint32
+	Magic      uint16
+	Frames     uint16
+	Width      uint16
+	Height     uint16
+	ColorDepth uint16
+}
+
+type aseFrameHeader struct {
+	Size       uint32
+	Magic      uint16
+	OldChunks  uint16
+	Duration   uint16
+	_          [2]byte
+	ChunkCount uint32
+}
+
+type aseCelHeader struct {
+	LayerIndex uint16
+	X          int16
+	Y          int16
+	Opacity    uint8
+	CelType    uint16
+	ZIndex     int16
+	_          [5]byte
+}
+
+type Cel struct {
+	layerIndex int
+	zIndex     int
+	x          int
+	y          int
+	w          int
+	h          int
+	pixels     []byte
+}
+
+type Sprite struct {
+	width  int
+	height int
+	cels   []Cel
+}
+
+func readSprite(path string) (*Sprite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+
+	var header aseHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != aseMagic {
+		return nil, errors.New("not an aseprite file")
+	}
+	if header.ColorDepth != 32 {
+		return nil, fmt.Errorf("unsupported color depth %d, expected RGBA", header.ColorDepth)
+	}
+	if header.Frames < 1 {
+		return nil, errors.New("file has no frames")
+	}
+	sprite := &Sprite{
+		width:  int(header.Width),
+		height: int(header.Height),
+	}
+
+	// only the first frame matters
+	if _, err := r.Seek(128, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var frame aseFrameHeader
+	if err := binary.Read(r, binary.LittleEndian, &frame); err != nil {
+		return nil, err
+	}
+	if frame.Magic != aseFrameMagic {
+		return nil, errors.New("bad frame magic")
+	}
+	chunks := int(frame.ChunkCount)
+	if chunks == 0 {
+		chunks = int(frame.OldChunks)
+	}
+
+	for i := 0; i < chunks; i++ {
+		var size uint32
+		var kind uint16
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &kind); err != nil {
+			return nil, err
+		}
+		if size < 6 {
+			return nil, fmt.Errorf("chunk %d has bad size %d", i, size)
+		}
+		body := make([]byte, size-6)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		if kind != chunkCel {
+			continue
+		}
+		cel, err := readCel(body)
+		if err != nil {
+			return nil, err
+		}
+		if cel != nil {
+			sprite.cels = append(sprite.cels, *cel)
+		}
+	}
+	return sprite, nil
+}
+
+func readCel(body []byte) (*Cel, error) {
+	r := bytes.NewReader(body)
+	var header aseCelHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.CelType != celRaw && header.CelType != celCompressed {
+		// linked cels and tilemaps never show up in levels.ase
+		return nil, nil
+	}
+	var w, h uint16
+	if err := binary.Read(r, binary.LittleEndian, &w); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	var pixels []byte
+	if header.CelType == celCompressed {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		pixels, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		pixels = make([]byte, r.Len())
+		r.Read(pixels)
+	}
+	if len(pixels) < int(w)*int(h)*4 {
+		return nil, fmt.Errorf("cel on layer %d is truncated", header.LayerIndex)
+	}
+	return &Cel{
+		layerIndex: int(header.LayerIndex),
+		zIndex:     int(header.ZIndex),
+		x:          int(header.X),
+		y:          int(header.Y),
+		w:          int(w),
+		h:          int(h),
+		pixels:     pixels,
+	}, nil
+}
+
+// Canvas returns the bottom-most cel placed on a sprite-sized RGBA buffer
+func (s *Sprite) Canvas() ([]byte, error) {
+	if len(s.cels) == 0 {
+		return nil, errors.New("first frame has no image cels")
+	}
+	cels := append([]Cel{}, s.cels...)
+	sort.SliceStable(cels, func(i, j int) bool {
+		a := cels[i].layerIndex + cels[i].zIndex
+		b := cels[j].layerIndex + cels[j].zIndex
+		if a != b {
+			return a < b
+		}
+		return cels[i].zIndex < cels[j].zIndex
+	})
+	cel := cels[0]
+	canvas := make([]byte, s.width*s.height*4)
+	for y := 0; y < cel.h; y++ {
+		for x := 0; x < cel.w; x++ {
+			cx := cel.x + x
+			cy := cel.y + y
+			if cx < 0 || cy < 0 || cx >= s.width || cy >= s.height {
+				continue
+			}
+			copy(canvas[(cy*s.width+cx)*4:], cel.pixels[(y*cel.w+x)*4:(y*cel.w+x)*4+4])
+		}
+	}
+	return canvas, nil
+}
@@ -0,0 +1,131 @@
+// slice cuts levels.ase into the per-room map/ and meta/ files the server
+// loads. Run it from the repo root: go run ./cmd/slice
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the sprite is a 10x10 grid of rooms
+const grid = 10
+
+func main() {
+	in := flag.String("in", "levels.ase", "aseprite file to slice")
+	mapDir := flag.String("map", "map", "where to write room tiles")
+	metaDir := flag.String("meta", "meta", "where to write room links and dialogue")
+	flag.Parse()
+
+	sprite, err := readSprite(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sprite.width%grid != 0 || sprite.height%grid != 0 {
+		log.Fatalf("sprite is %dx%d, which doesn't split into %dx%d rooms", sprite.width, sprite.height, grid, grid)
+	}
+	canvas, err := sprite.Canvas()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := sprite.width / grid
+	h := sprite.height / grid
+	for gr := 0; gr < grid; gr++ {
+		for gc := 0; gc < grid; gc++ {
+			name := fmt.Sprintf("%dx%d", gr, gc)
+			fmt.Println(name)
+			room := make([]byte, w*h*4)
+			npcs := []string{}
+			for ir := 0; ir < h; ir++ {
+				for ic := 0; ic < w; ic++ {
+					row := gr*h + ir
+					col := gc*w + ic
+					i := (row*sprite.width + col) * 4
+					j := (ir*w + ic) * 4
+					copy(room[j:j+4], canvas[i:i+4])
+					r, g, b, a := canvas[i], canvas[i+1], canvas[i+2], canvas[i+3]
+					if r == 0 && a == 255 && b > 0 && b < 255 && g == 0 {
+						// this is an NPC; it needs a line of dialogue
+						npcs = append(npcs, fmt.Sprintf("%dx%d placeholder %d", ic, ir, 254-b))
+					}
+				}
+			}
+			if err := os.WriteFile(filepath.Join(*mapDir, name+".txt"), room, 0o644); err != nil {
+				log.Fatal(err)
+			}
+			links := []string{
+				link(gr-1, gc),
+				link(gr, gc+1),
+				link(gr+1, gc),
+				link(gr, gc-1),
+			}
+			if err := writeMeta(filepath.Join(*metaDir, name+".txt"), links, npcs); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// link names the room at gr x gc, or NONE if that's off the edge of the sprite
+func link(gr int, gc int) string {
+	if gr < 0 || gc < 0 || gr >= grid || gc >= grid {
+		return "NONE"
+	}
+	return fmt.Sprintf("%dx%d", gr, gc)
+}
+
+// writeMeta rewrites the links but keeps any dialogue (and whether the room's
+// dark) that's already there, only adding placeholders for NPCs that don't
+// have a line yet
+func writeMeta(path string, links []string, npcs []string) error {
+	existing, err := readDialogue(path)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, line := range existing {
+		have[strings.SplitN(line, " ", 2)[0]] = true
+	}
+	lines := append([]string{}, links...)
+	lines = append(lines, existing...)
+	for _, npc := range npcs {
+		coords := strings.SplitN(npc, " ", 2)[0]
+		if !have[coords] {
+			lines = append(lines, npc)
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+func readDialogue(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	i := -1
+	for scanner.Scan() {
+		i++
+		// the first four lines are links
+		if i < 4 {
+			continue
+		}
+		if scanner.Text() == "" {
+			continue
+		}
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// New players make a character before they get to walk around: a name, a
+// class, and a color and a glyph to show up as. Names have to be clean and
+// nobody else who's on can have the same one. Players with a key get theirs
+// saved, so they only do this once, and nobody else gets to use the name
+// while they're away.
+
+const (
+	nameMin = 2
+	nameMax = 16
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// what you can look like. Red is for enemies.
+var (
+	characterColors = []string{"2", "4", "36", "3", "5", "208", "213", "99", "15"}
+	characterGlyphs = []string{"U", "&", "%", "$", "*", "~", "=", "^", "Q", "W"}
+)
+
+// Lines on the creation screen
+const (
+	CREATE_NAME = iota
+	CREATE_CLASS
+	CREATE_COLOR
+	CREATE_GLYPH
+	CREATE_FIELDS
+)
+
+// Character is how a player shows up to everybody else
+type Character struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	Glyph string `json:"glyph"`
+	Class string `json:"class"`
+}
+
+func (c Character) render(s string) string {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(c.Color)).Render(s)
+}
+
+// View is the character as it's drawn on the map
+func (c Character) View() string {
+	return c.render(c.Glyph)
+}
+
+type creation struct {
+	name  textinput.Model
+	field int
+	class int
+	color int
+	glyph int
+}
+
+func newCreation(c Character) creation {
+	cr := creation{name: textinput.New()}
+	cr.name.CharLimit = nameMax
+	cr.name.Prompt = ""
+	cr.name.Placeholder = "your name"
+	cr.name.SetValue(c.Name)
+	cr.name.Focus()
+	for i, color := range characterColors {
+		if color == c.Color {
+			cr.color = i
+		}
+	}
+	for i, glyph := range characterGlyphs {
+		if glyph == c.Glyph {
+			cr.glyph = i
+		}
+	}
+	for i, class := range classOrder {
+		if class == c.Class {
+			cr.class = i
+		}
+	}
+	return cr
+}
+
+func (cr creation) character() Character {
+	return Character{
+		Name:  strings.TrimSpace(cr.name.Value()),
+		Color: characterColors[cr.color],
+		Glyph: characterGlyphs[cr.glyph],
+		Class: classOrder[cr.class],
+	}
+}
+
+// checkName is whether a name is allowed at all, taken or not
+func (a *app) checkName(name string) error {
+	if len(name) < nameMin || len(name) > nameMax {
+		return fmt.Errorf("Names are %d to %d letters", nameMin, nameMax)
+	}
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("Names start with a letter and only have letters, numbers, _ and -")
+	}
+	if a.moderation.Profane(name) {
+		return fmt.Errorf("Pick a nicer name")
+	}
+	return nil
+}
+
+// claimCharacter takes a name for a player, unless somebody else on has it
+// or it's saved to somebody else's key
+func (a *app) claimCharacter(id string, key string, c Character) bool {
+	a.StateMutex.Lock()
+	defer a.StateMutex.Unlock()
+	for other, them := range a.Characters {
+		if other != id && strings.EqualFold(them.Name, c.Name) {
+			return false
+		}
+	}
+	// bots and load tests don't keep profiles
+	if a.profiles != nil && !a.profiles.Reserve(c.Name, key) {
+		return false
+	}
+	a.Characters[id] = c
+	return true
+}
+
+// rejoin takes back the character a player had last time, or sends them off
+// to make one
+func (m *model) rejoin() {
+	if m.character.Name == "" {
+		m.startCreating("")
+		return
+	}
+	if m.character.Class == "" {
+		// from before there were classes
+		m.startCreating("Pick a class")
+		return
+	}
+	if !m.tape.took(func() bool { return m.app.claimCharacter(m.id, m.key, m.character) }) {
+		m.startCreating(fmt.Sprintf("Somebody else is already %s", m.character.Name))
+	}
+}
+
+func (m *model) startCreating(why string) {
+	m.state = CREATING
+	m.creation = newCreation(m.character)
+	m.text = why
+}
+
+func (m *model) finishCreating() {
+	c := m.creation.character()
+	if err := m.app.checkName(c.Name); err != nil {
+		m.text = err.Error()
+		return
+	}
+	if !m.tape.took(func() bool { return m.app.claimCharacter(m.id, m.key, c) }) {
+		m.text = fmt.Sprintf("Somebody's already called %s", c.Name)
+		return
+	}
+	if m.character.Class == "" && m.level == 1 {
+		// a fresh character starts with their class's health
+		m.maxHealth = classDefs[c.Class].health
+		m.health = m.maxHealth
+	}
+	m.character = c
+	m.state = OVERWORLD
+	m.text = ""
+	m.creation.name.Blur()
+	// so everybody in the room sees you turn up
+	m.send(moveMsg{
+		id:  m.id,
+		pos: m.pos,
+	})
+}
+
+// updateCreation handles keys on the creation screen
+func (m *model) updateCreation(msg tea.KeyMsg) tea.Cmd {
+	cr := &m.creation
+	switch msg.String() {
+	case "ctrl+c":
+		m.hangUp()
+		return tea.Quit
+	case "enter":
+		m.finishCreating()
+		return nil
+	case "up", "shift+tab":
+		cr.field = (cr.field + CREATE_FIELDS - 1) % CREATE_FIELDS
+	case "down", "tab":
+		cr.field = (cr.field + 1) % CREATE_FIELDS
+	case "left", "right":
+		step := 1
+		if msg.String() == "left" {
+			step = -1
+		}
+		switch cr.field {
+		case CREATE_CLASS:
+			cr.class = (cr.class + step + len(classOrder)) % len(classOrder)
+			return nil
+		case CREATE_COLOR:
+			cr.color = (cr.color + step + len(characterColors)) % len(characterColors)
+			return nil
+		case CREATE_GLYPH:
+			cr.glyph = (cr.glyph + step + len(characterGlyphs)) % len(characterGlyphs)
+			return nil
+		}
+	}
+	if cr.field != CREATE_NAME {
+		cr.name.Blur()
+		return nil
+	}
+	if !cr.name.Focused() {
+		return cr.name.Focus()
+	}
+	var cmd tea.Cmd
+	cr.name, cmd = cr.name.Update(msg)
+	return cmd
+}
+
+func (cr creation) View() string {
+	c := cr.character()
+	pick := func(field int, label string, value string) string {
+		if cr.field == field {
+			return fmt.Sprintf(" %s %-6s %s\n", yellow(">"), label, value)
+		}
+		return fmt.Sprintf("   %-6s %s\n", label, value)
+	}
+	s := " Who are you?\n\n"
+	s += pick(CREATE_NAME, "Name", cr.name.View())
+	s += pick(CREATE_CLASS, "Class", "< "+c.Class+" >")
+	s += gray("          "+classDefs[c.Class].about) + "\n"
+	s += pick(CREATE_COLOR, "Color", "< "+c.render("██")+" >")
+	s += pick(CREATE_GLYPH, "Glyph", "< "+c.View()+" >")
+	s += "\n"
+	if c.Name != "" {
+		s += fmt.Sprintf(" You'll be %s %s\n", c.View(), c.render(c.Name))
+	}
+	s += "\n" + gray(" up and down to pick, left and right\n to change, enter when you're done")
+	return s
+}
+
+// how many other players fit in the roster
+const rosterSize = 6
+
+// rosterView is everybody in the room, next to the map
+func (m *model) rosterView(players []Player) string {
+	s := " " + m.character.View() + " " + m.character.render(m.character.Name) + "\n"
+	s += gray(fmt.Sprintf("   level %d %s", m.level, m.character.Class)) + "\n"
+	for i, p := range players {
+		if i == rosterSize && len(players) > rosterSize+1 {
+			s += gray(fmt.Sprintf(" and %d more", len(players)-rosterSize)) + "\n"
+			break
+		}
+		s += " " + p.character.View() + " " + p.character.Name + "\n"
+		s += gray(fmt.Sprintf("   level %d %s", p.level, p.character.Class)) + "\n"
+	}
+	return s
+}
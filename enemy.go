@@ -1,32 +1,66 @@
 package main
 
 import (
-	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
-// ENEMIES
+// Enemies live in bestiary.json. The id is what gets painted into the map:
+// an enemy with id n is red 255-n.
+const bestiaryPath = "./bestiary.json"
+
+// Things an enemy can do on its turn
 const (
-	ENEMY_BAT      = iota // 255
-	ENEMY_SKELETON        // 254
-	ENEMY_MINOTAUR        // 253
-	ENEMY_GHOSTS          // 252
-	ENEMY_KING            // 251
+	ACTION_ATTACK      = "attack"
+	ACTION_MULTIATTACK = "multiattack"
+	ACTION_DRAIN       = "drain"
+	ACTION_POISON      = "poison"
+	ACTION_FLEE        = "flee"
+	ACTION_SUMMON      = "summon"
 )
 
-//go:embed art/bat
-var batArt string
+// no enemy gets to call for help more than this many times a fight
+const maxSummons = 2
 
-//go:embed art/minotaur
-var minotaurArt string
+// EnemyAction is one entry in an enemy's policy. Each turn the enemy picks
+// one of the actions it's allowed to take, weighted by weight.
+type EnemyAction struct {
+	Type   string  `json:"type"`
+	Weight int     `json:"weight"`
+	Count  int     `json:"count"`  // multiattack: how many swings
+	Status string  `json:"status"` // stuck on the player when a swing lands
+	Turns  int     `json:"turns"`  // how long the status lasts
+	Below  float64 `json:"below"`  // flee: only below this fraction of health
+	Enemy  int     `json:"enemy"`  // summon: who shows up
+}
 
-//go:embed art/skeleton
-var skeletonArt string
+var defaultActions = []EnemyAction{{Type: ACTION_ATTACK, Weight: 1}}
 
-//go:embed art/ghosts
-var ghostsArt string
+type EnemyDef struct {
+	ID      int           `json:"id"`
+	Glyph   string        `json:"glyph"`
+	Name    string        `json:"name"`
+	Level   int           `json:"level"`
+	Health  int           `json:"health"`
+	AC      int           `json:"ac"`
+	Attack  string        `json:"attack"`
+	Damage  string        `json:"damage"`
+	XP      int           `json:"xp"`
+	Art     string        `json:"art"`
+	ArtPad  int           `json:"artPad"`
+	Actions []EnemyAction `json:"actions"`
+	Move    string        `json:"move"` // how it gets around, if it does
 
-//go:embed art/king
-var kingArt string
+	attack DiceExpr
+	damage DiceExpr
+	art    string
+}
 
 type Enemy struct {
 	id        int
@@ -36,79 +70,309 @@ type Enemy struct {
 	art       string
 	level     int
 	ac        int
-	attack    string
-	damage    string
+	attack    DiceExpr
+	damage    DiceExpr
+	xp        int
+	actions   []EnemyAction
+	summoned  int
+	statuses  Statuses
 }
 
-func createEnemy(c byte) *Enemy {
-	switch c {
-	case ENEMY_BAT:
-		return &Enemy{
-			id:        ENEMY_BAT,
-			name:      "a bat",
-			level:     1,
-			health:    5,
-			maxhealth: 5,
-			art:       leftpad(batArt, 5),
-			ac:        12,
-			damage:    "1d1",
-			attack:    "1d20",
-		}
-	case ENEMY_SKELETON:
-		return &Enemy{
-			id:        ENEMY_SKELETON,
-			name:      "a skeleton",
-			level:     2,
-			health:    10,
-			maxhealth: 10,
-			art:       leftpad(skeletonArt, 8),
-			ac:        12,
-			damage:    "1d4+1",
-			attack:    "1d20+1",
-		}
-	case ENEMY_GHOSTS:
-		return &Enemy{
-			id:        ENEMY_GHOSTS,
-			name:      "the ghosts",
-			level:     3,
-			health:    22,
-			maxhealth: 22,
-			art:       leftpad(ghostsArt, 3),
-			ac:        15,
-			damage:    "1d6",
-			attack:    "1d20+1",
-		}
-	case ENEMY_MINOTAUR:
-		return &Enemy{
-			id:        ENEMY_MINOTAUR,
-			name:      "the minotaur",
-			level:     4,
-			health:    18,
-			maxhealth: 18,
-			art:       leftpad(minotaurArt, 5),
-			ac:        12,
-			damage:    "1d10",
-			attack:    "1d20+1",
-		}
-	case ENEMY_KING:
-		return &Enemy{
-			name:      "the mad king",
-			id:        ENEMY_KING,
-			level:     20,
-			health:    45,
-			maxhealth: 45,
-			art:       leftpad(kingArt, 10),
-			ac:        15,
-			damage:    "2d6+2",
-			attack:    "1d20+2",
+// loadBestiary reads every enemy definition and checks it can be used in a fight
+func loadBestiary(path string) (map[byte]*EnemyDef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []*EnemyDef
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	bestiary := map[byte]*EnemyDef{}
+	var errs []error
+	for _, def := range defs {
+		if err := def.load(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: enemy %d (%s): %w", path, def.ID, def.Name, err))
+			continue
 		}
-	default:
+		if _, ok := bestiary[byte(def.ID)]; ok {
+			errs = append(errs, fmt.Errorf("%s: enemy id %d is used twice", path, def.ID))
+			continue
+		}
+		bestiary[byte(def.ID)] = def
+	}
+	// summons can only be checked once everybody's loaded
+	for _, def := range bestiary {
+		for _, action := range def.Actions {
+			if _, ok := bestiary[byte(action.Enemy)]; action.Type == ACTION_SUMMON && !ok {
+				errs = append(errs, fmt.Errorf("%s: enemy %d (%s): summons unknown enemy %d", path, def.ID, def.Name, action.Enemy))
+			}
+		}
+	}
+	return bestiary, errors.Join(errs...)
+}
+
+func (def *EnemyDef) load() error {
+	if def.ID < 0 || def.ID > 254 {
+		return fmt.Errorf("id must be between 0 and 254")
+	}
+	if utf8.RuneCountInString(def.Glyph) != 1 {
+		return fmt.Errorf("glyph must be a single character")
+	}
+	if def.Health <= 0 {
+		return fmt.Errorf("health must be positive")
+	}
+	if !validMove(def.Move) {
+		return fmt.Errorf("unknown move %q", def.Move)
+	}
+	attack, err := ParseDice(def.Attack)
+	if err != nil {
+		return fmt.Errorf("attack: %w", err)
+	}
+	damage, err := ParseDice(def.Damage)
+	if err != nil {
+		return fmt.Errorf("damage: %w", err)
+	}
+	def.attack = attack
+	def.damage = damage
+	if len(def.Actions) == 0 {
+		def.Actions = defaultActions
+	}
+	for i, action := range def.Actions {
+		// poison is just an attack that poisons you
+		if action.Type == ACTION_POISON && action.Status == "" {
+			def.Actions[i].Status = STATUS_POISONED
+			action.Status = STATUS_POISONED
+		}
+		if err := action.check(); err != nil {
+			return fmt.Errorf("%s: %w", action.Type, err)
+		}
+	}
+	if def.Art != "" {
+		art, err := os.ReadFile(def.Art)
+		if err != nil {
+			return err
+		}
+		def.art = leftpad(string(art), def.ArtPad)
+	}
+	return nil
+}
+
+// enemyGlyph is how an enemy is drawn on the map
+func (a *app) enemyGlyph(c byte) string {
+	if def, ok := a.bestiary[c]; ok {
+		return def.Glyph
+	}
+	return "?"
+}
+
+func (a *app) createEnemy(c byte) *Enemy {
+	def, ok := a.bestiary[c]
+	if !ok {
 		// this should never happen!
 		return &Enemy{
 			id:        -1,
 			name:      "MISSINGNO",
 			health:    100,
 			maxhealth: 100,
+			attack:    d20,
+			damage:    mustDice("1d1"),
 		}
 	}
+	return &Enemy{
+		id:        def.ID,
+		name:      def.Name,
+		level:     def.Level,
+		health:    def.Health,
+		maxhealth: def.Health,
+		art:       def.art,
+		ac:        def.AC,
+		damage:    def.damage,
+		attack:    def.attack,
+		xp:        def.XP,
+		actions:   def.Actions,
+	}
+}
+
+func (action EnemyAction) check() error {
+	if action.Weight <= 0 {
+		return fmt.Errorf("weight must be positive")
+	}
+	switch action.Type {
+	case ACTION_ATTACK, ACTION_DRAIN, ACTION_SUMMON:
+	case ACTION_MULTIATTACK:
+		if action.Count < 2 {
+			return fmt.Errorf("count must be at least 2")
+		}
+	case ACTION_POISON:
+		if action.Status != STATUS_POISONED {
+			return fmt.Errorf("poison can only poison")
+		}
+	case ACTION_FLEE:
+		if action.Below <= 0 || action.Below >= 1 {
+			return fmt.Errorf("below must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unknown action")
+	}
+	if action.Status != "" {
+		if !validStatus(action.Status) {
+			return fmt.Errorf("unknown status %q", action.Status)
+		}
+		if action.Turns <= 0 {
+			return fmt.Errorf("turns must be positive")
+		}
+	}
+	return nil
+}
+
+// allowed filters out actions that don't make sense right now
+func (e *Enemy) allowed(action EnemyAction) bool {
+	switch action.Type {
+	case ACTION_FLEE:
+		return float64(e.health) < action.Below*float64(e.maxhealth)
+	case ACTION_SUMMON:
+		return e.summoned < maxSummons
+	}
+	return true
+}
+
+func (e *Enemy) chooseAction(dice Dice) EnemyAction {
+	total := 0
+	for _, action := range e.actions {
+		if e.allowed(action) {
+			total += action.Weight
+		}
+	}
+	if total == 0 {
+		return defaultActions[0]
+	}
+	pick := dice.Intn(total)
+	for _, action := range e.actions {
+		if !e.allowed(action) {
+			continue
+		}
+		if pick < action.Weight {
+			return action
+		}
+		pick -= action.Weight
+	}
+	return defaultActions[0]
+}
+
+// swing is a single attack against the player, returning the damage dealt.
+// If it lands, the action's status (if any) sticks to the player.
+func (m *model) swing(action EnemyAction) (int, []string) {
+	enemy := m.fight.enemy
+	dice := m.fight.dice
+	if dice.Roll(enemy.attack)+enemy.statuses.AttackMod() < m.playerAc() {
+		return 0, []string{fmt.Sprintf("%s attacked, but missed!", enemy.name)}
+	}
+	dmg := dice.Roll(enemy.damage) + enemy.statuses.DamageMod()
+	if dmg < 1 {
+		dmg = 1
+	}
+	m.health -= dmg
+	lines := []string{fmt.Sprintf("%s dealt %d damage!", enemy.name, dmg)}
+	if action.Status != "" {
+		m.statuses = m.statuses.Apply(action.Status, action.Turns)
+		lines = append(lines, fmt.Sprintf("You are %s!", action.Status))
+	}
+	return dmg, lines
+}
+
+// tickStatuses runs a turn of the player's status effects
+func (m *model) tickStatuses() []string {
+	delta, lines, left := m.statuses.Tick("You", m.dice)
+	m.statuses = left
+	m.health += delta
+	if m.health > m.maxHealth {
+		m.health = m.maxHealth
+	}
+	return lines
+}
+
+// enemyTurn runs the enemy's side of a combat round, with this player as
+// the target
+func (m *model) enemyTurn() tea.Cmd {
+	f := m.fight
+	if f == nil {
+		return nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.over || f.current() != enemySlot || f.target != m.id {
+		return nil
+	}
+	enemy := f.enemy
+
+	lines := []string{}
+	if len(f.players()) > 1 {
+		lines = append(lines, fmt.Sprintf("%s turns on you!", enemy.name))
+	}
+	// a stun lasts the turn it skips, so look before it wears off
+	stunned := enemy.statuses.Stunned()
+	delta, enemyLines, left := enemy.statuses.Tick(enemy.name, f.dice)
+	enemy.statuses = left
+	enemy.health += delta
+	if enemy.health > enemy.maxhealth {
+		enemy.health = enemy.maxhealth
+	}
+	lines = append(lines, enemyLines...)
+	if enemy.health <= 0 {
+		enemy.health = 0
+		f.win(m.id, strings.Join(lines, "\n"))
+		return nil
+	}
+
+	action := enemy.chooseAction(f.dice)
+	if stunned {
+		action = EnemyAction{}
+		lines = append(lines, fmt.Sprintf("%s is stunned!", enemy.name))
+	}
+	switch action.Type {
+	case ACTION_ATTACK, ACTION_POISON:
+		_, text := m.swing(action)
+		lines = append(lines, text...)
+	case ACTION_MULTIATTACK:
+		for i := 0; i < action.Count; i++ {
+			_, text := m.swing(action)
+			lines = append(lines, text...)
+			if m.health <= 0 {
+				// no point hitting a corpse
+				break
+			}
+		}
+	case ACTION_DRAIN:
+		dmg, text := m.swing(action)
+		lines = append(lines, text...)
+		if dmg > 0 {
+			enemy.health += dmg
+			if enemy.health > enemy.maxhealth {
+				enemy.health = enemy.maxhealth
+			}
+			lines = append(lines, fmt.Sprintf("%s drained your life!", enemy.name))
+		}
+	case ACTION_SUMMON:
+		enemy.summoned++
+		ally := m.app.createEnemy(byte(action.Enemy))
+		f.reinforcements = append(f.reinforcements, ally)
+		lines = append(lines, fmt.Sprintf("%s called %s for help!", enemy.name, ally.name))
+	case ACTION_FLEE:
+		// it got away, but nobody gets anything for it
+		f.flee(fmt.Sprintf("%s fled!", enemy.name))
+		return nil
+	}
+
+	if m.health <= 0 {
+		return m.die(lines)
+	}
+	f.endTurn(m.id, strings.Join(lines, "\n"))
+	return nil
+}
+
+// endCombat puts the player back in the overworld and forgets the fight
+func (m *model) endCombat() {
+	m.state = OVERWORLD
+	m.fight = nil
 }
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Fights belong to the server, so players standing together can take on the
+// same enemy. Everybody rolls initiative, then the turn goes around the
+// order: the player who's up gets a YourTurnMsg, and on the enemy's turn it
+// picks somebody to hit and that player's program gets an EnemyMsg.
+
+// how long everyone gets to read what just happened
+const turnDelay = time.Millisecond * 1200
+
+// the enemy's slot in the initiative order
+const enemySlot = ""
+
+type combatant struct {
+	id         string
+	name       string
+	initiative int
+}
+
+type Fight struct {
+	mutex          sync.Mutex
+	app            *app
+	pos            Position
+	enemy          *Enemy
+	reinforcements []*Enemy
+	dice           Dice // the enemy's rolls, and who goes when
+	order          []combatant
+	turn           int
+	target         string // who the enemy is going after this turn
+	text           string // what just happened, from actor's point of view
+	actor          string
+	busy           bool // nobody can act right now
+	over           bool
+	step           int // bumped every time the turn is about to move on
+	turns          int // how many times it has
+}
+
+// joinFight puts a player into the fight at pos, starting one if needed. A
+// new fight's dice are seeded by whoever started it, so their recording can
+// replay it.
+func (a *app) joinFight(id string, name string, pos Position, c byte, seed int64) *Fight {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	if f, ok := a.fights[pos]; ok {
+		f.mutex.Lock()
+		if !f.over {
+			// fighting together puts you in the same party
+			if players := f.players(); len(players) > 0 {
+				a.worldState.JoinParty(id, players[0])
+			}
+			f.add(id, name)
+			f.text = fmt.Sprintf("You joined the fight against %s!", f.enemy.name)
+			f.actor = id
+			f.broadcast(fightMsg{})
+			f.mutex.Unlock()
+			return f
+		}
+		f.mutex.Unlock()
+	}
+	f := &Fight{
+		app:   a,
+		pos:   pos,
+		enemy: a.createEnemy(c),
+		dice:  NewDice(seed),
+	}
+	// whatever got shot on the way in is still hurt
+	if wounds := a.wounds[pos]; wounds > 0 {
+		f.enemy.health -= wounds
+		if f.enemy.health < 1 {
+			// the bestiary got reloaded in between
+			f.enemy.health = 1
+		}
+		delete(a.wounds, pos)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.order = []combatant{{id: enemySlot, initiative: f.dice.Roll(d20)}}
+	f.add(id, name)
+	// a new fight starts with whoever rolled highest
+	f.turn = 0
+	f.dispatch()
+	a.fights[pos] = f
+	return f
+}
+
+// nearbyFight finds a fight right next to pos
+func (a *app) nearbyFight(pos Position) (Position, byte, bool) {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	for _, d := range [][2]int{{0, 0}, {0, -1}, {1, 0}, {0, 1}, {-1, 0}} {
+		next := Position{world: pos.world, x: pos.x + d[0], y: pos.y + d[1]}
+		if mb, ok := a.mobAt(next); ok {
+			// fights are wherever the enemy started out
+			next = mb.home
+		} else if _, ok := a.mobs[next]; ok {
+			// it's off somewhere else
+			continue
+		}
+		if _, ok := a.fights[next]; ok {
+			cell := a.world[next.world][next.y][next.x]
+			return next, cell.toEnemy(), true
+		}
+	}
+	return Position{}, 0, false
+}
+
+func (a *app) removeFight(f *Fight) {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	if a.fights[f.pos] == f {
+		delete(a.fights, f.pos)
+	}
+	if mb, ok := a.mobs[f.pos]; ok {
+		// give everybody a moment to get away
+		mb.rest = mobRest
+	}
+}
+
+// add rolls initiative for a new player and slots them into the order
+// without changing whose turn it is
+func (f *Fight) add(id string, name string) {
+	c := combatant{id: id, name: name, initiative: f.dice.Roll(d20)}
+	i := sort.Search(len(f.order), func(i int) bool {
+		return f.order[i].initiative < c.initiative
+	})
+	f.order = append(f.order, combatant{})
+	copy(f.order[i+1:], f.order[i:])
+	f.order[i] = c
+	if i <= f.turn && len(f.order) > 1 {
+		f.turn++
+	}
+}
+
+func (f *Fight) players() []string {
+	out := []string{}
+	for _, c := range f.order {
+		if c.id != enemySlot {
+			out = append(out, c.id)
+		}
+	}
+	return out
+}
+
+func (f *Fight) current() string {
+	n := len(f.order)
+	return f.order[(f.turn%n+n)%n].id
+}
+
+// myTurn is whether this player can pick an action right now
+func (f *Fight) myTurn(id string) bool {
+	return !f.over && !f.busy && f.current() == id
+}
+
+func (f *Fight) broadcast(msg any) {
+	for _, id := range f.players() {
+		f.app.sendTo(id, msg)
+	}
+}
+
+// dispatch hands the turn to whoever is up
+func (f *Fight) dispatch() {
+	f.busy = true
+	cur := f.current()
+	if cur == enemySlot {
+		players := f.players()
+		f.target = players[f.dice.Intn(len(players))]
+		f.app.sendTo(f.target, EnemyMsg{})
+	} else {
+		f.app.sendTo(cur, YourTurnMsg{})
+	}
+	f.broadcast(fightMsg{})
+}
+
+// later moves the turn on once everyone's had a chance to read the result
+func (f *Fight) later() {
+	f.step++
+	step := f.step
+	f.app.after(turnDelay, func() {
+		f.advance(step)
+	})
+}
+
+func (f *Fight) advance(step int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.over || step != f.step {
+		return
+	}
+	f.turn = (f.turn + 1) % len(f.order)
+	f.turns++
+	f.text = ""
+	f.dispatch()
+}
+
+// endTurn shows what the actor just did to everyone, then moves on
+func (f *Fight) endTurn(actor string, text string) {
+	f.text = text
+	f.actor = actor
+	f.busy = true
+	f.broadcast(fightMsg{})
+	f.later()
+}
+
+// startTurn lets the current player pick an action
+func (f *Fight) startTurn(actor string, text string) {
+	f.text = text
+	f.actor = actor
+	f.busy = false
+	f.broadcast(fightMsg{})
+}
+
+// win splits the xp between everyone still standing, then either brings out
+// the next enemy or ends the fight
+func (f *Fight) win(actor string, text string) {
+	players := f.players()
+	last := len(f.reinforcements) == 0
+	for _, id := range players {
+		f.app.sendTo(id, DefeatEnemyMsg{
+			name: f.enemy.name,
+			xp:   f.enemy.xp / len(players),
+			pos:  f.pos,
+			last: last,
+		})
+	}
+	if last {
+		f.over = true
+		go f.app.removeFight(f)
+		return
+	}
+	f.enemy = f.reinforcements[0]
+	f.reinforcements = f.reinforcements[1:]
+	f.endTurn(actor, strings.TrimSpace(text+"\n"+fmt.Sprintf("%s steps up!", f.enemy.name)))
+}
+
+// flee ends the fight for everybody without a winner
+func (f *Fight) flee(text string) {
+	f.over = true
+	f.broadcast(fightOverMsg{text: text, pos: f.pos, destroyed: true})
+	go f.app.removeFight(f)
+}
+
+// leave takes a player out of the fight, because they ran, died or left
+func (f *Fight) leave(id string, text string) {
+	i := -1
+	for j, c := range f.order {
+		if c.id == id {
+			i = j
+		}
+	}
+	if i == -1 || f.over {
+		return
+	}
+	cur := f.current()
+	wasUp := cur == id || (cur == enemySlot && f.target == id)
+	f.order = append(f.order[:i], f.order[i+1:]...)
+	if i < f.turn || (cur == id && i == f.turn) {
+		// so the next advance lands on whoever came after them
+		f.turn--
+	}
+	if len(f.players()) == 0 {
+		f.over = true
+		go f.app.removeFight(f)
+		return
+	}
+	if text != "" {
+		f.text = text
+		f.actor = id
+		f.broadcast(fightMsg{})
+	}
+	if wasUp {
+		f.busy = true
+		f.later()
+	}
+}
+
+func (f *Fight) name(id string) string {
+	for _, c := range f.order {
+		if c.id == id {
+			return c.name
+		}
+	}
+	return "someone"
+}
+
+// textFor is the last thing that happened, told from this player's side
+func (f *Fight) textFor(id string, actorName string) string {
+	if f.actor == id || f.text == "" {
+		return f.text
+	}
+	return retell(f.text, actorName)
+}
+
+// retell turns "You dealt 3 damage!" into "somebody dealt 3 damage!"
+func retell(text string, name string) string {
+	return strings.NewReplacer(
+		"You are", name+" is",
+		"You ", name+" ",
+		" you!", " "+name+"!",
+		"yourself", "themselves",
+		"your ", name+"'s ",
+	).Replace(text)
+}
+
+// fighterName is how other players in a fight see you
+func (m *model) fighterName() string {
+	return m.character.Name
+}
+
+func (m *model) enterFight(pos Position, c byte) {
+	m.text = ""
+	m.combattext = ""
+	m.state = IN_COMBAT
+	m.abilityUsed = 0
+	m.allies = nil
+	seed := int64(m.dice.Intn(math.MaxInt32))
+	m.fight = m.app.joinFight(m.id, m.fighterName(), pos, c, seed)
+	m.updateOptions()
+}
+
+// fightSeen is how far the player's fight has got and who else is in it
+func (m *model) fightSeen() fightSeen {
+	m.fight.mutex.Lock()
+	defer m.fight.mutex.Unlock()
+	seen := fightSeen{Turns: m.fight.turns}
+	for _, id := range m.fight.players() {
+		if id != m.id {
+			seen.Company++
+		}
+	}
+	return seen
+}
+
+// joinNearbyFight jumps into a fight on or next to the player's cell
+func (m *model) joinNearbyFight() {
+	if m.state != OVERWORLD || m.falling || m.dead {
+		return
+	}
+	pos, c, ok := m.app.nearbyFight(m.pos)
+	if !ok {
+		m.text = "There's nobody to help."
+		return
+	}
+	m.prev = m.pos
+	m.enterFight(pos, c)
+}
+
+// leaveFight gets the player out of whatever fight they're in
+func (m *model) leaveFight(text string) {
+	if m.fight == nil {
+		return
+	}
+	m.fight.mutex.Lock()
+	m.fight.leave(m.id, text)
+	m.fight.mutex.Unlock()
+}
+
+// canPick is whether the picker should react to keys
+func (m *model) canPick() bool {
+	switch m.state {
+	case IN_NPC:
+		return len(m.combattext) == 0
+	case IN_COMBAT:
+		if m.fight == nil || m.dead {
+			return false
+		}
+		m.fight.mutex.Lock()
+		defer m.fight.mutex.Unlock()
+		return m.fight.myTurn(m.id)
+	}
+	return false
+}
+
+// yourTurn runs your status effects, then lets you act if you still can
+func (m *model) yourTurn() tea.Cmd {
+	f := m.fight
+	if f == nil {
+		return nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.over || f.current() != m.id {
+		return nil
+	}
+	// a stun lasts the turn it skips, so look before it wears off
+	stunned := m.statuses.Stunned()
+	lines := m.tickStatuses()
+	if m.health <= 0 {
+		return m.die(lines)
+	}
+	if stunned {
+		f.endTurn(m.id, strings.Join(append(lines, "You are stunned!"), "\n"))
+		return nil
+	}
+	// who's around to help changes from turn to turn
+	m.allies = nil
+	for _, c := range f.order {
+		if c.id != enemySlot && c.id != m.id {
+			m.allies = append(m.allies, c)
+		}
+	}
+	m.updateOptions()
+	f.startTurn(m.id, strings.Join(lines, "\n"))
+	return nil
+}
+
+// die takes you out of the fight; the caller holds the fight's lock
+func (m *model) die(lines []string) tea.Cmd {
+	m.health = 0
+	m.dead = true
+	m.fight.leave(m.id, strings.Join(append(lines, "You died!"), "\n"))
+	return DeadCmd
+}
+
+func (m *model) melee() {
+	f := m.fight
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.myTurn(m.id) {
+		return
+	}
+	m.updateOptions()
+	hit := m.playerAttack(false) >= f.enemy.ac+f.enemy.statuses.ACMod()
+	if !hit {
+		f.endTurn(m.id, "You missed!")
+		return
+	}
+	dmg := m.playerDamage()
+	m.hitEnemy(fmt.Sprintf("You dealt %d damage!", dmg), dmg)
+}
+
+// hitEnemy lands a blow with the player's weapon. The caller holds the
+// fight's lock.
+func (m *model) hitEnemy(text string, dmg int) {
+	f := m.fight
+	if weapon := m.inventory.Weapon(); weapon.status != "" {
+		f.enemy.statuses = f.enemy.statuses.Apply(weapon.status, weapon.turns)
+		text += fmt.Sprintf("\n%s %s %s!", f.enemy.name, be(f.enemy.name), weapon.status)
+	}
+	m.damageEnemy(text, dmg)
+}
+
+// damageEnemy takes dmg off the enemy and ends the turn, or the fight. The
+// caller holds the fight's lock.
+func (m *model) damageEnemy(text string, dmg int) {
+	f := m.fight
+	f.enemy.health -= dmg
+	if f.enemy.health <= 0 {
+		f.enemy.health = 0
+		f.win(m.id, text)
+		return
+	}
+	f.endTurn(m.id, text)
+}
+
+// use uses up a consumable. In a fight it takes your turn.
+func (m *model) use(id int) {
+	def := m.app.item(id)
+	if _, ok := m.inventory.Find(id); !ok || def.Category != CATEGORY_CONSUMABLE {
+		return
+	}
+	switch m.state {
+	case IN_COMBAT:
+		f := m.fight
+		if f == nil {
+			return
+		}
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if !f.myTurn(m.id) {
+			return
+		}
+		m.inventory.Consume(id)
+		m.updateOptions()
+		f.endTurn(m.id, strings.Join(m.drink(def), "\n"))
+	case IN_INVENTORY:
+		if m.dead || m.falling {
+			return
+		}
+		m.inventory.Consume(id)
+		m.text = strings.Join(m.drink(def), " ")
+	}
+}
+
+// drink does what a consumable says it does
+func (m *model) drink(def *ItemDef) []string {
+	healing := m.heal(m.dice.Roll(def.heals))
+	lines := []string{fmt.Sprintf("You healed for %d!", healing)}
+	if def.Status != "" {
+		m.statuses = m.statuses.Apply(def.Status, def.Turns)
+		lines = append(lines, fmt.Sprintf("You are %s!", def.Status))
+	}
+	return lines
+}
+
+// combatView is the fight as this player sees it
+func (m *model) combatView() string {
+	f := m.fight
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var s string
+	s += fmt.Sprintf("You entered combat with %s!\n", f.enemy.name)
+	if others := len(f.players()) - 1; others > 0 {
+		s += gray(fmt.Sprintf("Fighting alongside %d other", others))
+		if others > 1 {
+			s += gray("s")
+		}
+	}
+	s += "\n"
+	s += fmt.Sprintf("Enemy health: %d / %d %s\n", f.enemy.health, f.enemy.maxhealth, f.enemy.statuses.View())
+	s += f.enemy.art + "\n"
+	if m.dead {
+		return s + "You died!"
+	}
+	if text := f.textFor(m.id, f.name(f.actor)); text != "" {
+		s += text + "\n"
+	}
+	if f.myTurn(m.id) {
+		s += m.picker.View()
+	} else if f.text == "" && !f.over {
+		s += gray("Waiting for your turn...")
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+package main
+
+// Rooms are lit all over unless their meta file says they're dark. In a dark
+// room you only see what's near a light: you, anybody else standing around,
+// and further if you're holding a torch. Walls and closed doors keep the
+// light in. Whatever you've seen stays on your map, dimmed, for the rest of
+// the session; enemies and items don't, since they might not be there any
+// more. What you've seen is worked out whenever you or the light around you
+// moves, not when the map is drawn.
+
+// how far anybody can see in the dark with nothing in their hand
+const darkSight = 3
+
+type light struct {
+	pos    Position
+	radius int
+}
+
+// lightRadius is how far the player lights up a dark room
+func (m *model) lightRadius() int {
+	if r := m.inventory.LightRadius(); r > darkSight {
+		return r
+	}
+	return darkSight
+}
+
+// blocksLight is whether light stops at pos
+func (m *model) blocksLight(pos Position) bool {
+	if m.destroyed[pos] {
+		return false
+	}
+	cell := m.app.world[pos.world][pos.y][pos.x]
+	return cell.isWall() || cell.isSecret() || cell.isGate()
+}
+
+// lineClear walks a straight line from one cell to another and reports
+// whether nothing in between is blocked
+func lineClear(from Position, to Position, blocked func(Position) bool) bool {
+	x, y := from.x, from.y
+	dx, sx := to.x-x, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	dy, sy := to.y-y, 1
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	err := dx - dy
+	for x != to.x || y != to.y {
+		e := 2 * err
+		if e > -dy {
+			err -= dy
+			x += sx
+		}
+		if e < dx {
+			err += dx
+			y += sy
+		}
+		if (x != to.x || y != to.y) && blocked(Position{world: to.world, x: x, y: y}) {
+			return false
+		}
+	}
+	return true
+}
+
+// lit is every cell in the room the player can see right now, lit by
+// anything in lights, or nil if the room isn't dark
+func (m *model) lit(lights []light) map[Position]bool {
+	if !m.app.dark[m.pos.world] {
+		return nil
+	}
+	out := map[Position]bool{}
+	for y, row := range m.app.world[m.pos.world] {
+		for x := range row {
+			pos := Position{world: m.pos.world, x: x, y: y}
+			for _, l := range lights {
+				dx, dy := pos.x-l.pos.x, pos.y-l.pos.y
+				if dx*dx+dy*dy > l.radius*l.radius || !lineClear(l.pos, pos, m.blocksLight) {
+					continue
+				}
+				if lineClear(m.pos, pos, m.blocksLight) {
+					out[pos] = true
+				}
+				break
+			}
+		}
+	}
+	return out
+}
+
+// myLight is just the player's own light
+func (m *model) myLight() []light {
+	return []light{{pos: m.pos, radius: m.lightRadius()}}
+}
+
+// lights is the player's light and everybody else's in the room
+func (m *model) lights(others []Position) []light {
+	lights := m.myLight()
+	for _, pos := range others {
+		if pos.world == m.pos.world {
+			lights = append(lights, light{pos: pos, radius: darkSight})
+		}
+	}
+	return lights
+}
+
+// explore remembers everything the player can see in a dark room right now
+func (m *model) explore() {
+	if !m.app.dark[m.pos.world] {
+		return
+	}
+	others := m.tape.lights(func() []Position {
+		out := []Position{}
+		for _, p := range m.others() {
+			out = append(out, p.pos)
+		}
+		return out
+	})
+	for pos := range m.lit(m.lights(others)) {
+		m.explored[pos] = true
+	}
+}
+
+// sees is whether pos shows up, given what's lit
+func sees(lit map[Position]bool, pos Position) bool {
+	return lit == nil || lit[pos]
+}
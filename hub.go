@@ -0,0 +1,229 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+)
+
+// The hub owns who's where. Sessions publish events into one channel, and
+// the hub goroutine applies them in order and then only wakes up the players
+// in the room that changed.
+
+// how many events can pile up before publishing blocks
+const eventBuffer = 1024
+
+// how many messages can pile up for one player before we start dropping them
+const outboxBuffer = 256
+
+// program is anything the hub can send to: a real tea.Program, or a fake
+// player in the load test
+type program interface {
+	Send(msg tea.Msg)
+}
+
+// outbox hands messages to one program in the order they were sent, so a
+// slow player never holds up the hub or anybody else
+type outbox struct {
+	id   string
+	p    program
+	msgs chan tea.Msg
+}
+
+func newOutbox(id string, p program) *outbox {
+	o := &outbox{id: id, p: p, msgs: make(chan tea.Msg, outboxBuffer)}
+	go func() {
+		for msg := range o.msgs {
+			o.p.Send(msg)
+		}
+	}()
+	return o
+}
+
+// post only ever gets called with ProgramsMutex held, and close with it
+// locked, so nothing gets posted to a closed outbox
+func (o *outbox) post(msg tea.Msg) {
+	select {
+	case o.msgs <- msg:
+	default:
+		log.Warn("outbox full, dropping message", "id", o.id)
+	}
+}
+
+func (o *outbox) close() {
+	close(o.msgs)
+}
+
+type event struct {
+	msg tea.Msg
+	at  time.Time
+}
+
+type (
+	registerMsg struct {
+		id    string
+		ban   string // what mutes and bans stick to
+		p     program
+		pos   Position
+		level int
+	}
+	unregisterMsg struct {
+		id string
+	}
+)
+
+// publish hands an event to the hub
+func (a *app) publish(msg tea.Msg) {
+	a.events <- event{msg: msg, at: time.Now()}
+}
+
+func (a *app) runHub() {
+	for e := range a.events {
+		a.handle(e)
+	}
+}
+
+func (a *app) handle(e event) {
+	switch msg := e.msg.(type) {
+	case registerMsg:
+		a.ProgramsMutex.Lock()
+		if old, ok := a.programs[msg.id]; ok {
+			old.close()
+		}
+		a.programs[msg.id] = newOutbox(msg.id, msg.p)
+		a.ProgramsMutex.Unlock()
+		a.StateMutex.Lock()
+		a.Positions[msg.id] = msg.pos
+		a.Levels[msg.id] = msg.level
+		a.Chats[msg.id] = ""
+		a.BanKeys[msg.id] = msg.ban
+		a.StateMutex.Unlock()
+		if a.moderation.Muted(msg.ban) {
+			a.muted[msg.id] = true
+		}
+		a.subscribe(msg.id, msg.pos.world)
+		a.rerender(msg.pos.world, msg.id, e.at)
+	case unregisterMsg:
+		a.ProgramsMutex.Lock()
+		if o, ok := a.programs[msg.id]; ok {
+			o.close()
+			delete(a.programs, msg.id)
+		}
+		a.ProgramsMutex.Unlock()
+		a.StateMutex.Lock()
+		pos, ok := a.Positions[msg.id]
+		delete(a.Positions, msg.id)
+		delete(a.Chats, msg.id)
+		delete(a.Levels, msg.id)
+		delete(a.Characters, msg.id)
+		delete(a.BanKeys, msg.id)
+		a.StateMutex.Unlock()
+		delete(a.muted, msg.id)
+		a.moderation.Forget(msg.id)
+		if ok {
+			a.unsubscribe(msg.id, pos.world)
+			a.rerender(pos.world, msg.id, e.at)
+		}
+	case moveMsg:
+		a.StateMutex.Lock()
+		old, ok := a.Positions[msg.id]
+		if ok {
+			a.Positions[msg.id] = msg.pos
+		}
+		a.StateMutex.Unlock()
+		if !ok {
+			// they already left
+			return
+		}
+		if old.world != msg.pos.world {
+			a.unsubscribe(msg.id, old.world)
+			a.subscribe(msg.id, msg.pos.world)
+			a.rerender(old.world, msg.id, e.at)
+		}
+		a.rerender(msg.pos.world, msg.id, e.at)
+	case levelMsg:
+		if world, ok := a.setState(msg.id, func() { a.Levels[msg.id] = msg.level }); ok {
+			a.rerender(world, msg.id, e.at)
+		}
+	case muteMsg:
+		if msg.muted {
+			a.muted[msg.id] = true
+		} else {
+			delete(a.muted, msg.id)
+		}
+		// take down whatever they were saying
+		if world, ok := a.setState(msg.id, func() { a.Chats[msg.id] = "" }); ok {
+			a.rerender(world, "", e.at)
+		}
+	case ChatMsg:
+		// taking a bubble down is always fine
+		if msg.msg != "" {
+			clean, err := a.moderate(msg.id, msg.msg, e.at)
+			if err != nil {
+				a.sendTo(msg.id, chatLineMsg{line: systemLine(err.Error())})
+				return
+			}
+			msg.msg = clean
+		}
+		a.routeChat(msg, e.at)
+	case reportMsg:
+		a.report(msg, e.at)
+	case whoMsg:
+		a.sendTo(msg.id, chatLineMsg{line: a.who(msg.id)})
+	}
+}
+
+// setState changes something about a player who's still here, and returns
+// the room they're in
+func (a *app) setState(id string, set func()) (string, bool) {
+	a.StateMutex.Lock()
+	defer a.StateMutex.Unlock()
+	pos, ok := a.Positions[id]
+	if ok {
+		set()
+	}
+	return pos.world, ok
+}
+
+// rooms is only ever touched by the hub goroutine
+func (a *app) subscribe(id string, world string) {
+	if a.rooms[world] == nil {
+		a.rooms[world] = map[string]bool{}
+	}
+	a.rooms[world][id] = true
+}
+
+func (a *app) unsubscribe(id string, world string) {
+	delete(a.rooms[world], id)
+	if len(a.rooms[world]) == 0 {
+		delete(a.rooms, world)
+	}
+}
+
+// rerender wakes up everyone in a room except whoever caused it
+func (a *app) rerender(world string, except string, since time.Time) {
+	for id := range a.rooms[world] {
+		if id != except {
+			a.sendTo(id, rerenderMsg{since: since})
+		}
+	}
+}
+
+// sendTo sends a message straight to one player's program
+func (a *app) sendTo(id string, msg tea.Msg) {
+	a.ProgramsMutex.RLock()
+	defer a.ProgramsMutex.RUnlock()
+	if o, ok := a.programs[id]; ok {
+		o.post(msg)
+	}
+}
+
+// broadcast sends a message to everybody who's connected
+func (a *app) broadcast(msg tea.Msg) {
+	a.ProgramsMutex.RLock()
+	defer a.ProgramsMutex.RUnlock()
+	for _, o := range a.programs {
+		o.post(msg)
+	}
+}
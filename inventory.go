@@ -1,30 +1,94 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
+// Items live in items.json. The id is what gets painted into the map:
+// an item with id n is green 255-n.
+const itemsPath = "./items.json"
+
+// items the game itself needs to know about
+const (
+	ITEM_MUG = 1
+)
+
+// Item categories
 const (
-	ITEM_POTION = iota
-	ITEM_MUG
-	ITEM_SWORD1
-	ITEM_HEAVY_ARMOR
-	ITEM_LIGHT_ARMOR
-	ITEM_KINGSLAYER
-	ITEM_BONECRUSHER
+	CATEGORY_WEAPON     = "weapon"
+	CATEGORY_RANGED     = "ranged"
+	CATEGORY_AMMO       = "ammo"
+	CATEGORY_ARMOR      = "armor"
+	CATEGORY_SHIELD     = "shield"
+	CATEGORY_RING       = "ring"
+	CATEGORY_LIGHT      = "light"
+	CATEGORY_CONSUMABLE = "consumable"
+	CATEGORY_KEY        = "key"
+	CATEGORY_SCROLL     = "scroll"
+	CATEGORY_MISC       = "misc"
 )
 
+// you can wear one of each of these at a time
+var equippable = map[string]bool{
+	CATEGORY_WEAPON: true,
+	CATEGORY_RANGED: true,
+	CATEGORY_ARMOR:  true,
+	CATEGORY_SHIELD: true,
+	CATEGORY_RING:   true,
+	CATEGORY_LIGHT:  true,
+}
+
+type ItemDef struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Glyph       string `json:"glyph"`
+	Color       string `json:"color"`
+	Category    string `json:"category"`
+	AttackMod   int    `json:"attackMod"`
+	Dmg         string `json:"dmg"`
+	AC          int    `json:"ac"`
+	Heals       string `json:"heals"`
+	Opens       int    `json:"opens"`
+	Status      string `json:"status"` // weapons: on the enemy when you hit, consumables: on you
+	Turns       int    `json:"turns"`
+	Description string `json:"description"`
+	Spell       string `json:"spell"` // scrolls: what reading it teaches you
+	Range       int    `json:"range"` // ranged: how far it shoots
+	Ammo        int    `json:"ammo"`  // ranged: what it uses up, which can be itself
+	Count       int    `json:"count"` // how many you find at once
+	Light       int    `json:"light"` // lights: how far it lights up a dark room
+	Bane        *int   `json:"bane"`  // weapons: the enemy you always swing at with advantage
+
+	dmg    DiceExpr
+	heals  DiceExpr
+	render func(...string) string
+}
+
 type InventoryItem struct {
-	id        int
-	attackMod int
-	dmg       string
-	ac        int
-	name      string
-	qty       int
-	heals     string
-	equipped  bool
+	id          int
+	category    string
+	attackMod   int
+	dmg         DiceExpr
+	ac          int
+	name        string
+	qty         int
+	heals       DiceExpr
+	opens       int
+	status      string
+	turns       int
+	description string
+	reach       int
+	ammo        int
+	light       int
+	bane        *int
+	equipped    bool
 }
 
 type Inventory struct {
@@ -32,6 +96,127 @@ type Inventory struct {
 	item  int
 }
 
+// loadItems reads the item catalog and checks every item makes sense
+func loadItems(path string) (map[int]*ItemDef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []*ItemDef
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	items := map[int]*ItemDef{}
+	var errs []error
+	for _, def := range defs {
+		if err := def.load(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: item %d (%s): %w", path, def.ID, def.Name, err))
+			continue
+		}
+		if _, ok := items[def.ID]; ok {
+			errs = append(errs, fmt.Errorf("%s: item id %d is used twice", path, def.ID))
+			continue
+		}
+		items[def.ID] = def
+	}
+	for _, def := range items {
+		if def.Category != CATEGORY_RANGED || def.Ammo == def.ID {
+			continue
+		}
+		if ammo, ok := items[def.Ammo]; !ok || ammo.Category != CATEGORY_AMMO {
+			errs = append(errs, fmt.Errorf("%s: item %d (%s): ammo %d isn't ammo", path, def.ID, def.Name, def.Ammo))
+		}
+	}
+	return items, errors.Join(errs...)
+}
+
+func (def *ItemDef) load() error {
+	if def.ID < 0 || def.ID > 254 {
+		return fmt.Errorf("id must be between 0 and 254")
+	}
+	if utf8.RuneCountInString(def.Glyph) != 1 {
+		return fmt.Errorf("glyph must be a single character")
+	}
+	switch def.Category {
+	case CATEGORY_WEAPON:
+		dmg, err := ParseDice(def.Dmg)
+		if err != nil {
+			return fmt.Errorf("dmg: %w", err)
+		}
+		def.dmg = dmg
+	case CATEGORY_RANGED:
+		dmg, err := ParseDice(def.Dmg)
+		if err != nil {
+			return fmt.Errorf("dmg: %w", err)
+		}
+		def.dmg = dmg
+		if def.Range < 2 {
+			return fmt.Errorf("ranged weapons need a range of at least 2")
+		}
+	case CATEGORY_ARMOR:
+		if def.AC == 0 {
+			return fmt.Errorf("armor needs an ac")
+		}
+	case CATEGORY_CONSUMABLE:
+		heals, err := ParseDice(def.Heals)
+		if err != nil {
+			return fmt.Errorf("heals: %w", err)
+		}
+		def.heals = heals
+	case CATEGORY_KEY:
+		if def.Opens <= 0 {
+			return fmt.Errorf("key needs to open something")
+		}
+	case CATEGORY_SCROLL:
+		if !validSpell(def.Spell) {
+			return fmt.Errorf("unknown spell %q", def.Spell)
+		}
+	case CATEGORY_LIGHT:
+		if def.Light <= darkSight {
+			return fmt.Errorf("light needs to reach further than %d", darkSight)
+		}
+	case CATEGORY_SHIELD, CATEGORY_RING, CATEGORY_AMMO, CATEGORY_MISC:
+	default:
+		return fmt.Errorf("unknown category %q", def.Category)
+	}
+	if def.Count < 0 {
+		return fmt.Errorf("count can't be negative")
+	}
+	if def.Bane != nil {
+		if def.Category != CATEGORY_WEAPON {
+			return fmt.Errorf("only weapons can have a bane")
+		}
+		if *def.Bane < 0 || *def.Bane > 254 {
+			return fmt.Errorf("bane must be an enemy id between 0 and 254")
+		}
+	}
+	if def.Status != "" {
+		if !validStatus(def.Status) {
+			return fmt.Errorf("unknown status %q", def.Status)
+		}
+		if def.Turns <= 0 {
+			return fmt.Errorf("status needs turns")
+		}
+	}
+	def.render = lipgloss.NewStyle().Foreground(lipgloss.Color(def.Color)).Render
+	return nil
+}
+
+// item looks up an item in the catalog, falling back to something harmless
+func (a *app) item(id int) *ItemDef {
+	if def, ok := a.items[id]; ok {
+		return def
+	}
+	// this should never happen!
+	return &ItemDef{
+		ID:       id,
+		Name:     "Mysterious thing",
+		Glyph:    "I",
+		Category: CATEGORY_MISC,
+		render:   yellow,
+	}
+}
+
 func (m *Inventory) Count(id int) int {
 	for _, j := range m.items {
 		if j.id == id {
@@ -46,80 +231,54 @@ func (m *Inventory) Consume(id int) {
 			m.items[s].qty--
 			if m.items[s].qty == 0 {
 				m.items = append(m.items[:s], m.items[s+1:]...)
+				if m.item >= len(m.items) && m.item > 0 {
+					m.item = len(m.items) - 1
+				}
 			}
 			return
 		}
 	}
 }
-func (m *Inventory) AddItem(id int) InventoryItem {
-	for i, j := range m.items {
+func (m *Inventory) Find(id int) (InventoryItem, bool) {
+	for _, j := range m.items {
 		if j.id == id {
+			return j, true
+		}
+	}
+	return InventoryItem{}, false
+}
+func (m *Inventory) AddItem(def *ItemDef) InventoryItem {
+	for i, j := range m.items {
+		if j.id == def.ID {
 			m.items[i].qty++
 			return j
 		}
 	}
-	var item InventoryItem
-	switch id {
-	case ITEM_POTION:
-		item = InventoryItem{
-			id:    id,
-			qty:   1,
-			name:  "Healing potion",
-			heals: "2d4+2",
-		}
-	case ITEM_MUG:
-		item = InventoryItem{
-			id:   id,
-			qty:  1,
-			name: "Empty mug",
-			dmg:  "1d4",
-		}
-	case ITEM_LIGHT_ARMOR:
-		item = InventoryItem{
-			id:   id,
-			qty:  1,
-			ac:   13,
-			name: "Leather Armor",
-		}
-	case ITEM_HEAVY_ARMOR:
-		item = InventoryItem{
-			id:   id,
-			qty:  1,
-			ac:   16,
-			name: "Heavy Armor",
-		}
-	case ITEM_SWORD1:
-		item = InventoryItem{
-			id:        id,
-			qty:       1,
-			attackMod: 1,
-			name:      "Sword",
-			dmg:       "1d6",
-		}
-	case ITEM_BONECRUSHER:
-		item = InventoryItem{
-			id:        id,
-			qty:       1,
-			attackMod: 2,
-			name:      "Bonecrusher",
-			dmg:       "1d8",
-		}
-	case ITEM_KINGSLAYER:
-		item = InventoryItem{
-			id:        id,
-			qty:       1,
-			attackMod: 3,
-			name:      "Kingslayer",
-			dmg:       "1d10+1",
-		}
+	item := InventoryItem{
+		id:          def.ID,
+		category:    def.Category,
+		qty:         1,
+		name:        def.Name,
+		attackMod:   def.AttackMod,
+		dmg:         def.dmg,
+		ac:          def.AC,
+		heals:       def.heals,
+		opens:       def.Opens,
+		status:      def.Status,
+		turns:       def.Turns,
+		description: def.Description,
+		reach:       def.Range,
+		ammo:        def.Ammo,
+		light:       def.Light,
+		bane:        def.Bane,
 	}
 	m.items = append(m.items, item)
 	return item
 }
-func NewInventory() Inventory {
+func (a *app) NewInventory() Inventory {
 	var m Inventory
 	m.items = []InventoryItem{}
-	m.AddItem(ITEM_MUG)
+	m.AddItem(a.item(ITEM_MUG))
 	return m
 }
 
@@ -138,26 +297,20 @@ func (m *Inventory) Update(msg tea.Msg) (Inventory, tea.Cmd) {
 func (m *Inventory) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 	switch msg.String() {
 	case "enter":
+		if m.item >= len(m.items) {
+			return nil
+		}
 		i := m.items[m.item]
-		if i.dmg != "" {
-			if i.equipped {
-				m.items[m.item].equipped = false
-			} else {
-				// unequip existing stuff
-				for j, it := range m.items {
-					if it.dmg != "" && it.equipped {
-						m.items[j].equipped = false
-					}
-				}
-				m.items[m.item].equipped = true
-			}
-		} else if i.ac != 0 {
+		if i.category == CATEGORY_CONSUMABLE {
+			return UseCmd(i.id)
+		}
+		if equippable[i.category] {
 			if i.equipped {
 				m.items[m.item].equipped = false
 			} else {
 				// unequip existing stuff
 				for j, it := range m.items {
-					if it.ac != 0 && it.equipped {
+					if it.category == i.category && it.equipped {
 						m.items[j].equipped = false
 					}
 				}
@@ -180,28 +333,123 @@ func (m *Inventory) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 
 var defaultWeapon = InventoryItem{
 	name:      "fists",
-	dmg:       "1d2+1",
+	category:  CATEGORY_WEAPON,
+	dmg:       mustDice("1d2+1"),
 	attackMod: 0,
 }
 
+// ArmorClass is your armor (or 10 without any), plus whatever else you're wearing
 func (m *Inventory) ArmorClass() int {
+	ac := 10
+	bonus := 0
+	for _, it := range m.items {
+		if !it.equipped {
+			continue
+		}
+		switch it.category {
+		case CATEGORY_ARMOR:
+			ac = it.ac
+		case CATEGORY_SHIELD, CATEGORY_RING:
+			bonus += it.ac
+		}
+	}
+	return ac + bonus
+}
+
+// AttackMod is your weapon's bonus plus any rings
+func (m *Inventory) AttackMod() int {
+	return m.Weapon().attackMod + m.ringMod()
+}
+
+func (m *Inventory) ringMod() int {
+	mod := 0
 	for _, it := range m.items {
-		if it.ac != 0 && it.equipped {
-			return it.ac
+		if it.equipped && it.category == CATEGORY_RING {
+			mod += it.attackMod
 		}
 	}
-	return 10
+	return mod
+}
+
+// Ranged is the ranged weapon you have out, if any
+func (m *Inventory) Ranged() (InventoryItem, bool) {
+	for _, it := range m.items {
+		if it.category == CATEGORY_RANGED && it.equipped {
+			return it, true
+		}
+	}
+	return InventoryItem{}, false
+}
+
+// LightRadius is how far the light you're holding reaches, or 0
+func (m *Inventory) LightRadius() int {
+	for _, it := range m.items {
+		if it.category == CATEGORY_LIGHT && it.equipped {
+			return it.light
+		}
+	}
+	return 0
 }
 
 func (m *Inventory) Weapon() InventoryItem {
 	for _, it := range m.items {
-		if it.dmg != "" && it.equipped {
+		if it.category == CATEGORY_WEAPON && it.equipped {
 			return it
 		}
 	}
 	return defaultWeapon
 }
 
+// banes is whether this is the weapon for fighting a particular enemy
+func (it InventoryItem) banes(enemy int) bool {
+	return it.bane != nil && *it.bane == enemy
+}
+
+// Opens reports whether you're carrying a key for a door of this level
+func (m *Inventory) Opens(level int) bool {
+	for _, it := range m.items {
+		if it.category == CATEGORY_KEY && it.opens >= level {
+			return true
+		}
+	}
+	return false
+}
+
+func (it InventoryItem) Description() string {
+	if it.description != "" {
+		return it.description
+	}
+	switch it.category {
+	case CATEGORY_WEAPON:
+		if it.status != "" {
+			return fmt.Sprintf("+%d Weapon (%s dmg, %s)", it.attackMod, it.dmg, it.status)
+		}
+		return fmt.Sprintf("+%d Weapon (%s dmg)", it.attackMod, it.dmg)
+	case CATEGORY_RANGED:
+		return fmt.Sprintf("+%d Ranged (%s dmg, range %d)", it.attackMod, it.dmg, it.reach)
+	case CATEGORY_AMMO:
+		return "Ammo"
+	case CATEGORY_ARMOR:
+		return fmt.Sprintf("Armor (%d AC)", it.ac)
+	case CATEGORY_SHIELD:
+		return fmt.Sprintf("Shield (+%d AC)", it.ac)
+	case CATEGORY_RING:
+		return fmt.Sprintf("Ring (+%d attack, +%d AC)", it.attackMod, it.ac)
+	case CATEGORY_CONSUMABLE:
+		if it.status != "" {
+			return fmt.Sprintf("Healing (%s HP, %s)", it.heals, it.status)
+		}
+		return fmt.Sprintf("Healing (%s HP)", it.heals)
+	case CATEGORY_LIGHT:
+		return fmt.Sprintf("Light (reaches %d)", it.light)
+	case CATEGORY_KEY:
+		return "Key"
+	case CATEGORY_SCROLL:
+		return "Scroll"
+	}
+	return "Item"
+}
+
 func (m *Inventory) View() string {
 	var out string
 	out += "Inventory\n\n"
@@ -212,17 +460,7 @@ func (m *Inventory) View() string {
 		}
 		if i == m.item {
 			out += blue(fmt.Sprintf("%s %dx %s %s", ">", item.qty, item.name, eq)) + "\n"
-			var description string
-			if item.dmg != "" {
-				description = fmt.Sprintf("+%d Weapon (%s dmg)", item.attackMod, item.dmg)
-			} else if item.ac != 0 {
-				description = fmt.Sprintf("Armor (%d AC)", item.ac)
-			} else if item.heals != "" {
-				description = fmt.Sprintf("Healing (%s HP)", item.heals)
-			} else {
-				description = "Item"
-			}
-			out += fmt.Sprintf("    -> %s", description) + "\n"
+			out += fmt.Sprintf("    -> %s", item.Description()) + "\n"
 		} else {
 			out += fmt.Sprintf("  %dx %s %s\n", item.qty, item.name, eq)
 		}
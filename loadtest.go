@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakePlayer stands in for a tea.Program and remembers how long every
+// redraw took to reach it
+type fakePlayer struct {
+	id        string
+	pos       Position
+	mutex     sync.Mutex
+	latencies []time.Duration
+}
+
+func (f *fakePlayer) Send(msg tea.Msg) {
+	if msg, ok := msg.(rerenderMsg); ok {
+		f.mutex.Lock()
+		f.latencies = append(f.latencies, time.Since(msg.since))
+		f.mutex.Unlock()
+	}
+}
+
+// wander takes one step in a random direction, and every so often walks
+// into another room instead
+func (f *fakePlayer) wander(worlds []string) {
+	if rand.Intn(20) == 0 {
+		f.pos.world = worlds[rand.Intn(len(worlds))]
+		return
+	}
+	d := [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}[rand.Intn(4)]
+	f.pos.x = (f.pos.x + d[0] + 40) % 40
+	f.pos.y = (f.pos.y + d[1] + 16) % 16
+}
+
+// runLoadTest walks a crowd of fake players around the hub and reports how
+// long their moves take to reach everybody else in the room
+func runLoadTest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	sessions := flags.Int("sessions", 300, "how many fake players to connect")
+	moves := flags.Int("moves", 50, "how many steps each player takes")
+	every := flags.Duration("every", 100*time.Millisecond, "how long each player waits between steps")
+	flags.Parse(args)
+	if *sessions < 1 || *moves < 1 {
+		fmt.Println("need at least one session and one move")
+		return 2
+	}
+
+	a := newApp()
+	if err := a.loadLevels(); err != nil {
+		fmt.Println("load error:", err)
+		return 1
+	}
+	worlds := []string{}
+	for world := range a.world {
+		worlds = append(worlds, world)
+	}
+	sort.Strings(worlds)
+	go a.runHub()
+	go a.watchMobs()
+
+	players := []*fakePlayer{}
+	for i := 0; i < *sessions; i++ {
+		f := &fakePlayer{
+			id:  fmt.Sprintf("loadtest-%d", i),
+			pos: Position{world: worlds[i%len(worlds)], x: rand.Intn(40), y: rand.Intn(16)},
+		}
+		players = append(players, f)
+		a.publish(registerMsg{id: f.id, p: f, pos: f.pos, level: 1})
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, f := range players {
+		wg.Add(1)
+		go func(f *fakePlayer) {
+			defer wg.Done()
+			// don't have everyone step at exactly the same time
+			time.Sleep(time.Duration(rand.Int63n(int64(*every))))
+			for i := 0; i < *moves; i++ {
+				f.wander(worlds)
+				a.publish(moveMsg{id: f.id, pos: f.pos})
+				time.Sleep(*every)
+			}
+		}(f)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// let the last redraws land before counting
+	time.Sleep(time.Second)
+	for _, f := range players {
+		a.publish(unregisterMsg{id: f.id})
+	}
+
+	all := []time.Duration{}
+	for _, f := range players {
+		f.mutex.Lock()
+		all = append(all, f.latencies...)
+		f.mutex.Unlock()
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	sent := *sessions * *moves
+	fmt.Printf("%d sessions in %d rooms, %d moves in %s (%.0f moves/s)\n",
+		*sessions, len(worlds), sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	fmt.Printf("%d redraws sent, %.1f per move\n", len(all), float64(len(all))/float64(sent))
+	if len(all) == 0 {
+		return 0
+	}
+	pct := func(p float64) time.Duration {
+		return all[int(p*float64(len(all)-1))]
+	}
+	fmt.Printf("latency p50 %s  p90 %s  p99 %s  max %s\n", pct(0.5), pct(0.9), pct(0.99), all[len(all)-1])
+	return 0
+}
@@ -8,13 +8,13 @@ import (
 	"math"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	goaway "github.com/TwiN/go-away"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -27,6 +27,7 @@ import (
 
 	lm "github.com/charmbracelet/wish/logging"
 	"github.com/muesli/termenv"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func parsePort(port string) int {
@@ -52,16 +53,62 @@ var (
 	darkgray = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render
 )
 
-func (a *app) loadLevels() {
+// loadLevels loads every room it can and returns everything that went wrong
+func (a *app) loadLevels() error {
+	var errs []error
+	bestiary, err := loadBestiary(bestiaryPath)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	a.bestiary = bestiary
+	items, err := loadItems(itemsPath)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	a.items = items
+	tiles, err := loadTiles(tilesPath)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	a.tiles = tiles
+	rules, err := loadChatRules(chatRulesPath)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		a.moderation.setRules(rules)
+	}
+
 	entries, err := os.ReadDir("./map")
 	if err != nil {
-		log.Fatal(err)
+		return errors.Join(append(errs, err)...)
 	}
 
+	drawn := map[string]string{} // room -> the file it came from
 	for _, e := range entries {
-		a.loadLevel(strings.Split(e.Name(), ".")[0])
-		a.loadMeta(strings.Split(e.Name(), ".")[0])
+		world, ext, _ := strings.Cut(e.Name(), ".")
+		if ext != "txt" && ext != "map" {
+			continue
+		}
+		if first, ok := drawn[world]; ok {
+			errs = append(errs, fmt.Errorf("%s: drawn in both %s and %s", world, first, e.Name()))
+			continue
+		}
+		drawn[world] = e.Name()
+		if ext == "txt" {
+			err = a.loadLevel(world)
+		} else {
+			err = a.loadAsciiLevel(world)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := a.loadMeta(world); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	a.spawnMobs()
+	return errors.Join(errs...)
 }
 
 type Color struct {
@@ -101,6 +148,20 @@ func (c Color) isCarpet() bool {
 func (c Color) isFence() bool {
 	return c.b == 0 && c.a == 100 && c.r == 0 && c.g == 0
 }
+
+// hazards are floor you can walk on, but it leaves something on you
+var hazards = map[byte]string{
+	200: STATUS_POISONED,
+	220: STATUS_BURNING,
+}
+
+func (c Color) isHazard() bool {
+	_, ok := hazards[c.a]
+	return ok && c.r == 0 && c.g == 0 && c.b == 0
+}
+func (c Color) toHazard() string {
+	return hazards[c.a]
+}
 func (c Color) isSpawn() bool {
 	return c.b == 255 && c.a == 255 && c.r == 0 && c.g == 0
 }
@@ -126,91 +187,90 @@ func (c Color) isGate() bool {
 	return c.r == 0 && c.a == 255 && c.g < 255 && c.g > 200 && c.b == 255
 }
 
-func (c Color) render(destroyed bool, x int, y int) string {
+// glyph is what a cell looks like and what colour it's drawn in, if any
+func (c Color) glyph(a *app, destroyed bool, x int, y int) (string, func(...string) string) {
 	if c.isNPC() {
 		switch c.toNPC() {
 		case NPC_SIGN:
-			return blue("S")
+			return "S", blue
 		default:
-			return blue("N")
+			return "N", blue
 		}
 	}
 	if c.isCarpet() {
-		return red("@")
+		return "@", red
 	}
 	if c.isFence() {
-		return gray("+")
+		return "+", gray
+	}
+	if c.isHazard() {
+		switch c.toHazard() {
+		case STATUS_POISONED:
+			return "~", green
+		default:
+			return "^", red
+		}
 	}
 	if c.isGrass() {
 		hash := (y*14 + x*3) % 8
 		switch hash {
 		case 0:
-			return green("\"")
+			return "\"", green
 		case 1:
-			return green(",")
+			return ",", green
 		case 2:
-			return green("'")
+			return "'", green
 		case 3:
-			return green(".")
+			return ".", green
 		default:
-			return " "
+			return " ", nil
 		}
 	}
 	if c.isGate() {
 		if destroyed {
-			return " "
+			return " ", nil
 		}
-		return cyan("D")
+		return "D", cyan
 	}
 	if c.isWall() {
-		return "#"
+		return "#", nil
 	}
 	if c.isSecret() {
 		if destroyed {
-			return darkgray("#")
+			return "#", darkgray
 		}
-		return "#"
+		return "#", nil
 	}
 	if c.isHole() && destroyed {
-		return gray("X")
+		return "X", gray
 	}
 	if c.isItem() && !destroyed {
-		var letter string
-		switch c.toItem() {
-		case ITEM_POTION:
-			letter = "P"
-		case ITEM_SWORD1:
-			letter = "S"
-		case ITEM_KINGSLAYER:
-			letter = "ʈ"
-		case ITEM_BONECRUSHER:
-			letter = "¶"
-		case ITEM_HEAVY_ARMOR:
-			letter = "H"
-		case ITEM_LIGHT_ARMOR:
-			letter = "A"
-		default:
-			letter = "I"
-		}
-		return yellow(letter)
+		def := a.item(c.toItem())
+		return def.Glyph, def.render
 	}
 	if c.isEnemy() && !destroyed {
-		var letter string
-		switch c.toEnemy() {
-		case ENEMY_BAT:
-			letter = "b"
-		case ENEMY_GHOSTS:
-			letter = "G"
-		case ENEMY_SKELETON:
-			letter = "s"
-		case ENEMY_MINOTAUR:
-			letter = "M"
-		case ENEMY_KING:
-			letter = "K"
-		}
-		return red(letter)
+		return a.enemyGlyph(c.toEnemy()), red
+	}
+	return " ", nil
+}
+
+// render draws a cell the way it looks right now
+func (c Color) render(a *app, destroyed bool, x int, y int) string {
+	glyph, style := c.glyph(a, destroyed, x, y)
+	if style == nil {
+		return glyph
 	}
-	return " "
+	return style(glyph)
+}
+
+// remembered draws a cell the way you remember it from a dark room: dimmed,
+// and without anything that might have moved since
+func (c Color) remembered(a *app, destroyed bool, x int, y int) string {
+	glyph, _ := c.glyph(a, destroyed, x, y)
+	if glyph == " " || c.isItem() || c.isEnemy() {
+		glyph = "."
+	}
+	return darkgray(glyph)
 }
 
 func (m *model) inCombatView() bool {
@@ -222,45 +282,59 @@ func (m *model) inCombatView() bool {
 	}
 	return false
 }
-func (a *app) loadLevel(world string) {
-	file, err := os.Open("./map/" + world + ".txt")
+func (a *app) loadLevel(world string) error {
+	data, err := os.ReadFile("./map/" + world + ".txt")
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer file.Close()
 	tmp := [16][40]Color{}
-	buf := make([]byte, 4)
-	i := 0
-	for {
-		n, err := file.Read(buf)
-		if n == 0 || err != nil {
-			break
-		}
+	if len(data) != len(tmp)*len(tmp[0])*4 {
+		return fmt.Errorf("%s: map is %d bytes, want %d", world, len(data), len(tmp)*len(tmp[0])*4)
+	}
+	for i := 0; i*4 < len(data); i++ {
 		j := i / 40
 		k := i % 40
-		c := Color{
-			r: buf[0],
-			g: buf[1],
-			b: buf[2],
-			a: buf[3],
+		tmp[j][k] = Color{
+			r: data[i*4],
+			g: data[i*4+1],
+			b: data[i*4+2],
+			a: data[i*4+3],
 		}
-		if c.isSpawn() {
-			fmt.Println("i found spawn")
-			a.StartPos = Position{
-				x:     k,
-				y:     j,
-				world: world,
+	}
+	a.addLevel(world, tmp)
+	return nil
+}
+func (a *app) loadAsciiLevel(world string) error {
+	file, err := os.Open("./map/" + world + ".map")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tmp, err := parseAsciiLevel(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", world, err)
+	}
+	a.addLevel(world, tmp)
+	return nil
+}
+func (a *app) addLevel(world string, tmp [16][40]Color) {
+	for j, row := range tmp {
+		for k, c := range row {
+			if c.isSpawn() {
+				a.StartPos = Position{
+					x:     k,
+					y:     j,
+					world: world,
+				}
 			}
 		}
-		tmp[j][k] = c
-		i++
 	}
 	a.world[world] = tmp
 }
-func (a *app) loadMeta(world string) {
+func (a *app) loadMeta(world string) error {
 	file, err := os.Open("./meta/" + world + ".txt")
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
 
@@ -277,15 +351,25 @@ func (a *app) loadMeta(world string) {
 			a.links[world] = append(a.links[world], line)
 			continue
 		}
+		if line == "" {
+			continue
+		}
+		if line == "dark" {
+			a.dark[world] = true
+			continue
+		}
 		parts := strings.SplitN(line, " ", 2)
 		coords := strings.Split(parts[0], "x")
+		if len(parts) != 2 || len(coords) != 2 {
+			return fmt.Errorf("%s: line %d: want \"XxY dialogue\" or \"dark\", got %q", world, i+1, line)
+		}
 		x, err := strconv.Atoi(coords[0])
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%s: line %d: %w", world, i+1, err)
 		}
 		y, err := strconv.Atoi(coords[1])
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%s: line %d: %w", world, i+1, err)
 		}
 		a.dialogue[Position{
 			world: world,
@@ -295,8 +379,22 @@ func (a *app) loadMeta(world string) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		return err
+	}
+	if len(a.links[world]) != 4 {
+		return fmt.Errorf("%s: meta has %d links, want 4", world, len(a.links[world]))
 	}
+	return nil
+}
+
+// linkedRoom returns the room on the other side of an edge, if there is one
+func (a *app) linkedRoom(world string, warp int) (string, bool) {
+	links := a.links[world]
+	if warp >= len(links) {
+		return "", false
+	}
+	_, ok := a.world[links[warp]]
+	return links[warp], ok
 }
 
 func MiddlewareWithProgramHandler(bth bm.ProgramHandler, cp termenv.Profile) wish.Middleware {
@@ -339,65 +437,97 @@ func MiddlewareWithProgramHandler(bth bm.ProgramHandler, cp termenv.Profile) wis
 		}
 	}
 }
-func main() {
+func newApp() *app {
 	a := new(app)
 	a.links = make(map[string]([]string))
+	a.dark = make(map[string]bool)
 	a.dialogue = make(map[Position]string)
 	a.world = make(map[string]([16][40]Color))
-	a.loadLevels()
 	a.Positions = make(map[string]Position)
 	a.Levels = make(map[string]int)
 	a.Chats = make(map[string]string)
-	a.Chans = make(map[string](chan tea.Msg))
-	go func() {
-		fmt.Println("I am the server!")
-		for {
-			updates := []tea.Msg{}
-			updated := false
-			time.Sleep(time.Millisecond * 100)
-			a.ChansMutex.Lock()
-			a.StateMutex.Lock()
-			for _, ch := range a.Chans {
-			loop:
-				for {
-					// fmt.Printf("length of channel %s: %d\n", id, len(ch))
-					var thing tea.Msg
-					select {
-					case thing = <-ch:
-					default:
-						break loop
-					}
-					switch msg := thing.(type) {
-					case levelMsg:
-						a.Levels[msg.id] = msg.level
-					case ChatMsg:
-						a.Chats[msg.id] = msg.msg
-						updated = true
-					case HoleMsg:
-						updates = append(updates, msg)
-						updated = true
-					case DeadMsg:
-						delete(a.Positions, msg.id)
-						delete(a.Chats, msg.id)
-						delete(a.Levels, msg.id)
-						updated = true
-					case moveMsg:
-						// fmt.Printf("got a move msg from %s\n", msg.id)
-						a.Positions[msg.id] = msg.pos
-						updated = true
-					}
-				}
-			}
-			a.StateMutex.Unlock()
-			a.ChansMutex.Unlock()
-			if updated {
-				a.send2(rerenderMsg{updates: updates})
-			}
+	a.Characters = make(map[string]Character)
+	a.BanKeys = make(map[string]string)
+	a.programs = make(map[string]*outbox)
+	a.rooms = make(map[string]map[string]bool)
+	a.events = make(chan event, eventBuffer)
+	a.fights = make(map[Position]*Fight)
+	a.wounds = make(map[Position]int)
+	a.mobs = make(map[Position]*mob)
+	a.admins = make(map[string]bool)
+	a.muted = make(map[string]bool)
+	a.moderation = NewModeration("", "")
+	a.worldState = NewWorldState()
+	a.dice = NewDice(newSeed())
+	a.after = func(d time.Duration, f func()) {
+		time.AfterFunc(d, f)
+	}
+	return a
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate())
+		case "loadtest":
+			os.Exit(runLoadTest(os.Args[2:]))
+		case "bots":
+			os.Exit(runBots(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			os.Exit(2)
 		}
-	}()
+	}
+	a := newApp()
+	if seed := os.Getenv("DICE_SEED"); seed != "" {
+		n, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			log.Fatal("DICE_SEED has to be a number", "error", err)
+		}
+		a.dice = NewDice(n)
+	}
+	log.Info("rolling dice", "seed", a.dice.Seed())
+	if err := a.loadLevels(); err != nil {
+		log.Fatal(err)
+	}
+	a.profiles = NewProfileStore(profileDir)
+	admins, err := loadAdmins(adminsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a.admins = admins
+	log.Info("loaded admins", "count", len(admins))
+	a.moderation.path = moderationPath
+	a.moderation.reports = reportsPath
+	if err := a.moderation.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("RECORD") == "on" {
+		if err := os.MkdirAll(recordingsDir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+		a.recordings = recordingsDir
+		go a.pruneRecordings()
+	}
+	go a.watchLevels()
+	go a.watchRespawns()
+	go a.watchMobs()
+	fmt.Println("I am the server!")
+	go a.runHub()
 	s, err := wish.NewServer(
 		wish.WithAddress(fmt.Sprintf("%s:%d", host, port)),
 		wish.WithHostKeyPath(".ssh/term_info_ed25519"),
+		// accept any key; it's only used to find your character
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return true
+		}),
+		// players without a key can still play, they just don't get saved
+		wish.WithKeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			return true
+		}),
 		wish.WithMiddleware(
 			MiddlewareWithProgramHandler(a.ProgramHandler, termenv.ANSI256),
 			lm.Middleware(),
@@ -437,7 +567,9 @@ type (
 		pos Position
 	}
 	rerenderMsg struct {
-		updates []tea.Msg
+		since time.Time // when the change we're redrawing for happened
+	}
+	reloadMsg struct {
 	}
 	RespawnMsg struct {
 	}
@@ -445,26 +577,40 @@ type (
 	}
 	MeleeMsg struct {
 	}
-	HealingMsg struct {
+	UseMsg struct {
+		item int
 	}
 	EnemyMsg struct {
 	}
 	DefeatEnemyMsg struct {
+		name string
+		xp   int
+		pos  Position
+		last bool
 	}
 	YourTurnMsg struct {
 	}
+	fightMsg struct {
+	}
+	fightOverMsg struct {
+		text      string
+		pos       Position
+		destroyed bool
+	}
 	ChatMsg struct {
-		id  string
-		msg string
+		id      string
+		channel string
+		to      string // who a whisper is for
+		msg     string
+		emote   bool
 	}
-	HoleMsg struct {
-		id  string
-		pos Position
+	tileMsg struct {
+		pos  Position
+		gone bool
 	}
 	DisconnectMsg struct {
 	}
 	DeadMsg struct {
-		id string
 	}
 	ChatClearMsg struct {
 		msg string
@@ -480,63 +626,64 @@ var DeadCmd tea.Cmd = func() tea.Msg {
 	return DeadMsg{}
 }
 
-var HealingCmd tea.Cmd = func() tea.Msg {
-	return HealingMsg{}
+func UseCmd(item int) tea.Cmd {
+	return func() tea.Msg {
+		return UseMsg{item: item}
+	}
 }
+
 var MeleeCmd tea.Cmd = func() tea.Msg {
 	return MeleeMsg{}
 }
 
-var YourTurnCmd tea.Cmd = func() tea.Msg {
-	time.Sleep(time.Millisecond * 1200)
-	return YourTurnMsg{}
-}
-var EnemyCmd tea.Cmd = func() tea.Msg {
-	time.Sleep(time.Millisecond * 1200)
-	return EnemyMsg{}
-}
-var DefeatEnemyCmd tea.Cmd = func() tea.Msg {
-	time.Sleep(time.Millisecond * 1200)
-	return DefeatEnemyMsg{}
-}
-
 // app contains a wish server and the list of running programs.
 type app struct {
 	*ssh.Server
-	progs      []*tea.Program
-	Positions  map[string]Position
-	Levels     map[string]int
-	Chats      map[string]string
-	Chans      map[string](chan tea.Msg)
-	ChansMutex sync.Mutex
-	StateMutex sync.RWMutex
-	world      map[string]([16][40]Color)
-	links      map[string]([]string)
-	dialogue   map[Position](string)
-	StartPos   Position
+	programs      map[string]*outbox // by player id
+	ProgramsMutex sync.RWMutex
+	rooms         map[string]map[string]bool
+	events        chan event
+	Positions     map[string]Position
+	Levels        map[string]int
+	Chats         map[string]string
+	Characters    map[string]Character // nobody's in here till they've made one
+	BanKeys       map[string]string
+	StateMutex    sync.RWMutex
+	WorldMutex    sync.RWMutex
+	world         map[string]([16][40]Color)
+	links         map[string]([]string)
+	dark          map[string]bool // rooms you need a light in
+	dialogue      map[Position](string)
+	bestiary      map[byte]*EnemyDef
+	items         map[int]*ItemDef
+	tiles         map[string]*TileRule
+	StartPos      Position
+	profiles      *ProfileStore
+	fights        map[Position]*Fight
+	wounds        map[Position]int  // damage enemies took before anybody fought them
+	mobs          map[Position]*mob // enemies that move, by home; under FightsMutex
+	FightsMutex   sync.Mutex
+	worldState    *WorldState
+	dice          *SeededDice // only used to seed everybody else's dice
+	after         func(d time.Duration, f func())
+	recordings    string          // where sessions get recorded, if anywhere
+	admins        map[string]bool // by key
+	muted         map[string]bool // only touched by the hub
+	moderation    *Moderation
 }
 
-func (a *app) send2(msg tea.Msg) {
-	for _, p := range a.progs {
-		go p.Send(msg)
-	}
-}
-
-// send dispatches a message to the server thread.
+// send dispatches a message to the hub.
 func (m *model) send(msg tea.Msg) {
-	m.serverChan <- msg
+	m.app.publish(msg)
 }
 
-func (a *app) ProgramHandler(s ssh.Session) *tea.Program {
-	pty, _, active := s.Pty()
-	if !active {
-		wish.Fatalln(s, "terminal is not active")
-	}
-
+// newModel sets up a fresh player, loading their profile if they have one.
+// The caller holds WorldMutex.
+func (a *app) newModel(id string, key string, term string, width int, height int) model {
 	m := model{
-		term:           pty.Term,
-		width:          pty.Window.Width,
-		height:         pty.Window.Height,
+		term:           term,
+		width:          width,
+		height:         height,
 		pos:            a.StartPos,
 		roomStart:      a.StartPos,
 		health:         7,
@@ -545,36 +692,81 @@ func (a *app) ProgramHandler(s ssh.Session) *tea.Program {
 		xp:             0,
 		state:          OVERWORLD,
 		destroyed:      map[Position]bool{},
+		explored:       map[Position]bool{},
 		percent:        0.0,
 		progress:       progress.New(progress.WithSolidFill("63"), progress.WithColorProfile(termenv.ANSI256)),
 		progressHealth: progress.New(progress.WithSolidFill("1"), progress.WithColorProfile(termenv.ANSI256)),
-		inventory:      NewInventory(),
+		progressMana:   progress.New(progress.WithSolidFill("27"), progress.WithColorProfile(termenv.ANSI256)),
+		inventory:      a.NewInventory(),
 		chat:           textinput.New(),
+		console:        textinput.New(),
 	}
-	m.chat.CharLimit = 30
-	m.chat.Placeholder = "press T to chat"
+	m.chat.CharLimit = 60
+	m.chat.Placeholder = "press T to chat, /help for more"
+	m.channel = CHANNEL_ROOM
+	m.console.CharLimit = 80
+	m.console.Prompt = ": "
 	m.app = a
-	m.id = s.RemoteAddr().String() + s.User()
-	a.StateMutex.Lock()
-	a.Positions[m.id] = m.pos
-	a.Levels[m.id] = 1
-	a.Chats[m.id] = ""
-	a.StateMutex.Unlock()
+	m.tape = liveTape{}
+	m.seed = a.nextSeed()
+	m.dice = NewDice(m.seed)
+	m.id = id
+	m.key = key
+	if m.key != "" {
+		profile, err := a.profiles.Load(m.key)
+		if err != nil {
+			log.Error("could not load profile", "key", m.key, "error", err)
+		} else if profile != nil {
+			m.applyProfile(profile)
+		}
+	}
+	m.enterRoom()
 	m.progress.Width = 19
 	m.progress.ShowPercentage = false
 	m.progressHealth.Width = 19
 	m.progressHealth.ShowPercentage = false
-	if strings.Split(s.RemoteAddr().String(), ":")[0] == "127.0.0.1" {
-		m.hacks = true
+	m.progressMana.Width = 19
+	m.progressMana.ShowPercentage = false
+	return m
+}
+
+func (a *app) ProgramHandler(s ssh.Session) *tea.Program {
+	pty, _, active := s.Pty()
+	if !active {
+		wish.Fatalln(s, "terminal is not active")
 	}
 
-	ch := make(chan tea.Msg, 100)
-	a.ChansMutex.Lock()
-	defer a.ChansMutex.Unlock()
-	a.Chans[m.id] = ch
-	m.serverChan = ch
+	ban := banKey(keyID(s.PublicKey()), s.RemoteAddr())
+	if a.moderation.Banned(ban) {
+		log.Info("banned player turned away", "user", s.User(), "ban", ban)
+		wish.Fatalln(s, "You've been banned.")
+		return nil
+	}
+
+	a.WorldMutex.RLock()
+	defer a.WorldMutex.RUnlock()
+	m := a.newModel(s.RemoteAddr().String()+s.User(), keyID(s.PublicKey()), pty.Term, pty.Window.Width, pty.Window.Height)
+	if a.admins[m.key] {
+		m.admin = true
+		log.Info("admin connected", "id", m.id, "key", m.key)
+	}
+	if a.recordings != "" {
+		if rec, err := a.record(&m); err != nil {
+			log.Error("could not record session", "id", m.id, "error", err)
+		} else {
+			m.tape = rec
+		}
+	}
+	m.rejoin()
+
 	p := tea.NewProgram(m, tea.WithOutput(s), tea.WithInput(s), tea.WithAltScreen())
-	a.progs = append(a.progs, p)
+	a.publish(registerMsg{id: m.id, ban: ban, p: p, pos: m.pos, level: m.level})
+	// however the session ends, the hub forgets about it
+	go func() {
+		<-s.Context().Done()
+		a.publish(unregisterMsg{id: m.id})
+		m.tape.close()
+	}()
 
 	return p
 }
@@ -591,12 +783,15 @@ const (
 	IN_INVENTORY
 	IN_COMBAT
 	IN_NPC
+	CREATING // making a character, before anything else
+	IN_SPELLS
+	AIMING
 )
 
 type model struct {
 	*app
-	serverChan     chan tea.Msg
 	id             string
+	key            string
 	term           string
 	width          int
 	height         int
@@ -609,7 +804,12 @@ type model struct {
 	xp             int
 	state          int
 	falling        bool
-	enemy          *Enemy
+	fight          *Fight
+	statuses       Statuses
+	dice           Dice
+	seed           int64 // what dice started from
+	tape           tape
+	dead           bool
 	npc            *NPC
 	destroyed      map[Position]bool
 	chattext       string
@@ -619,11 +819,31 @@ type model struct {
 	picker         PickerModel
 	progress       progress.Model
 	progressHealth progress.Model
+	progressMana   progress.Model
 	percent        float64
 	inventory      Inventory
-	hacks          bool
+	admin          bool
+	console        textinput.Model
 	chat           textinput.Model
 	allowchat      bool
+	character      Character
+	creation       creation
+	abilityUsed    int         // this fight
+	allies         []combatant // who else was in the fight when your turn came up
+	channel        string      // where chat goes when you just type
+	chatLog        []ChatLine  // everything you've heard
+	chatScroll     int         // how many lines up from the bottom you're looking
+	sent           []string    // what you've said, for up and down
+	recall         int
+	spells         []string // in the order you learned them
+	manaSpent      int      // so max mana can change under it
+	walked         int      // steps, for getting mana back
+	spellbook      PickerModel
+	targets        []Position // what you can shoot at while aiming
+	aim            int
+	mobs           map[Position]Position // where the room's moving enemies are, by home
+	explored       map[Position]bool     // everywhere you've seen in a dark room
+	players        []Player              // who else was around after the last update
 }
 
 func (m model) Init() tea.Cmd {
@@ -653,6 +873,9 @@ func (m *model) doWarp() {
 		m.pos.world = m.app.links[m.pos.world][warp]
 		m.roomStart = m.pos
 		m.text = ""
+		m.enterRoom()
+		// so a crash doesn't lose the whole session
+		m.saveProfile()
 	}
 }
 
@@ -660,39 +883,81 @@ func (m *model) xpCurve(x int) int {
 	return int(math.Pow(1+0.5, float64(x-1))*1000) - 1000
 }
 
+func (m *model) updateXpPercent() {
+	base := m.xpCurve(m.level)
+	next := m.xpCurve(m.level + 1)
+	xpNeeded := next - base
+	xpHave := m.xp - base
+	m.percent = float64(xpHave) / float64(xpNeeded)
+}
+
 func (m *model) pickupItems() {
 	if _, ok := m.destroyed[m.pos]; ok {
 		return
 	}
 	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
-	if cell.isItem() {
-		m.destroyed[m.pos] = true
-		item := m.inventory.AddItem(cell.toItem())
+	// somebody else might have just grabbed it
+	if cell.isItem() && m.clear(m.pos) {
+		def := m.app.item(cell.toItem())
+		if def.Category == CATEGORY_SCROLL {
+			m.text = m.learn(def.Spell)
+			return
+		}
+		item := m.inventory.AddItem(def)
 		m.text = fmt.Sprintf("Found %s!", item.name)
+		if def.Count > 1 {
+			for i := 1; i < def.Count; i++ {
+				m.inventory.AddItem(def)
+			}
+			m.text = fmt.Sprintf("Found %d %s!", def.Count, item.name)
+		}
 	}
 }
 
+// how long the healing fountain's blessing lasts
+const blessingTurns = 20
+
 func (m *model) doHeals() {
 	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
 	if cell.isHeal() {
 		m.health = m.maxHealth
+		m.manaSpent = 0
+		m.statuses = m.statuses.Apply(STATUS_BLESSED, blessingTurns)
 		m.text = "You feel refreshed."
 	}
 }
+
+// how long stepping in a hazard sticks to you
+const hazardTurns = 5
+
+func (m *model) doHazards() {
+	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
+	if !cell.isHazard() {
+		return
+	}
+	status := cell.toHazard()
+	m.statuses = m.statuses.Apply(status, hazardTurns)
+	switch status {
+	case STATUS_POISONED:
+		m.text = "The swamp water is poisonous!"
+	default:
+		m.text = "The embers set you alight!"
+	}
+}
+
 func (m *model) revealSecrets() {
 	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
 	if cell.isSecret() {
-		m.destroyed[m.pos] = true
+		m.clear(m.pos)
 	}
 }
 
 func (m *model) checkTraps() tea.Cmd {
 	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
 	if cell.isHole() {
-		m.destroyed[m.pos] = true
+		m.clear(m.pos)
 		m.text = "You fell in a hole!"
 		m.falling = true
-		m.send(HoleMsg{id: m.id, pos: m.pos})
 		var cmd tea.Cmd = func() tea.Msg {
 			time.Sleep(time.Second)
 			return RespawnMsg{}
@@ -707,53 +972,83 @@ func (m *model) updateOptions() {
 	if m.state == IN_NPC {
 		m.picker.items = []PickerItem{
 			{
-				text: "continue",
+				text:   "continue",
+				action: RunCmd,
 			},
 		}
 		return
 	}
+	weapon := m.inventory.Weapon()
 	m.picker.items = []PickerItem{
 		{
-			text: fmt.Sprintf("melee attack (%s)", m.inventory.Weapon().name),
+			text:    fmt.Sprintf("melee attack (%s)", weapon.name),
+			action:  MeleeCmd,
+			tooltip: weapon.Description(),
 		},
 		{
-			text: "run away",
+			text:   "run away",
+			action: RunCmd,
 		},
 	}
-	count := m.inventory.Count(ITEM_POTION)
-	if count > 0 {
-		m.picker.items = append(m.picker.items, PickerItem{
-			text: fmt.Sprintf("healing potion (%d)", count),
-		})
+	for _, it := range m.inventory.items {
+		if it.category != CATEGORY_CONSUMABLE {
+			continue
+		}
+		item := PickerItem{
+			text:    fmt.Sprintf("%s (%d)", strings.ToLower(it.name), it.qty),
+			action:  UseCmd(it.id),
+			tooltip: it.Description(),
+		}
+		if m.health >= m.maxHealth && it.status == "" {
+			item.disabled = true
+			item.tooltip = "You're already at full health"
+		}
+		m.picker.items = append(m.picker.items, item)
 	}
+	m.picker.items = append(m.picker.items, m.abilityOptions()...)
+	m.picker.items = append(m.picker.items, m.spellOptions(true)...)
 }
 func (m *model) startCombat() {
-	if _, ok := m.destroyed[m.pos]; ok {
-		return
+	if home, c, ok := m.enemyAt(m.pos); ok {
+		m.enterFight(home, c)
 	}
-	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
-	if cell.isEnemy() {
-		m.text = ""
-		m.combattext = ""
-		m.state = IN_COMBAT
-		m.enemy = createEnemy(cell.toEnemy())
-		m.updateOptions()
+}
+
+func (m *model) canWarp(warp int) bool {
+	world, ok := m.app.linkedRoom(m.pos.world, warp)
+	if !ok {
+		return false
 	}
+	// rooms don't always line up, so don't walk into a wall on the other side
+	room := m.app.world[world]
+	dest := Position{world: world, x: m.pos.x, y: m.pos.y}
+	switch warp {
+	case 0:
+		dest.y = len(room) - 1
+	case 1:
+		dest.x = 0
+	case 2:
+		dest.y = 0
+	case 3:
+		dest.x = len(room[0]) - 1
+	}
+	return m.standable(dest)
 }
 
 func (m *model) isBlocked() bool {
 	_, ok := m.destroyed[m.pos]
+	// walking off the edge only works if there's a room to walk into
 	if m.pos.y < 0 {
-		return false
+		return !m.canWarp(0)
 	}
 	if m.pos.x < 0 {
-		return false
+		return !m.canWarp(3)
 	}
 	if m.pos.y >= len(m.app.world[m.pos.world]) {
-		return false
+		return !m.canWarp(2)
 	}
 	if m.pos.x >= len(m.app.world[m.pos.world][m.pos.y]) {
-		return false
+		return !m.canWarp(1)
 	}
 	cell := m.app.world[m.pos.world][m.pos.y][m.pos.x]
 	if cell.isNPC() {
@@ -766,12 +1061,15 @@ func (m *model) isBlocked() bool {
 	if cell.isFence() {
 		return true
 	}
-	if cell.isGate() && m.level < int(cell.toGateLevel()) {
+	if cell.isGate() && !ok && m.level < int(cell.toGateLevel()) && !m.inventory.Opens(int(cell.toGateLevel())) {
 		m.text = fmt.Sprintf("You must be level %d.", cell.toGateLevel())
 		return true
 	}
 	if cell.isGate() {
-		m.text = "The door opened!"
+		if !ok {
+			m.text = "The door opened!"
+			m.clear(m.pos)
+		}
 		return false
 	}
 	if ok {
@@ -788,32 +1086,28 @@ func (m *model) isBlocked() bool {
 }
 
 func (m *model) playerAc() int {
-	return m.inventory.ArmorClass()
+	return m.inventory.ArmorClass() + m.statuses.ACMod()
 }
 
-func (m *model) playerAttack() int {
-	var advantage bool
-	if m.enemy.id == ENEMY_KING && m.inventory.Weapon().id == ITEM_KINGSLAYER {
-		advantage = true
-	}
-	roll1 := Roll(fmt.Sprintf("1d20+%d", m.inventory.Weapon().attackMod+4))
-	if !advantage {
-		return roll1
-	}
-	roll2 := Roll(fmt.Sprintf("1d20+%d", m.inventory.Weapon().attackMod+4))
-	if roll1 > roll2 {
-		return roll1
+func (m *model) playerAttack(advantage bool) int {
+	mode := ROLL_NORMAL
+	if advantage || m.inventory.Weapon().banes(m.fight.enemy.id) {
+		mode = ROLL_ADVANTAGE
 	}
-	return roll2
+	return m.dice.RollMode(d20.Plus(m.inventory.AttackMod()+m.class().attack), mode) + m.statuses.AttackMod()
 }
 
 func (m *model) playerDamage() int {
-	return Roll(m.inventory.Weapon().dmg)
+	dmg := m.dice.Roll(m.inventory.Weapon().dmg) + m.statuses.DamageMod()
+	if dmg < 1 {
+		return 1
+	}
+	return dmg
 }
 
 func (m *model) move(x int, y int) tea.Cmd {
 	var cmd tea.Cmd
-	if m.falling {
+	if m.falling || m.dead {
 		return nil
 	}
 	if m.state != OVERWORLD {
@@ -831,16 +1125,58 @@ func (m *model) move(x int, y int) tea.Cmd {
 		cmd = m.checkTraps()
 		m.revealSecrets()
 		m.doHeals()
+		m.doHazards()
 		m.pickupItems()
+		m.regainMana()
+		m.explore()
 		m.send(moveMsg{
 			id:  m.id,
 			pos: m.pos,
 		})
+		// every step outside of a fight is a turn for your status effects
+		if m.state == OVERWORLD && len(m.statuses) > 0 {
+			lines := m.tickStatuses()
+			if len(lines) > 0 {
+				m.text = lines[0]
+			}
+			if m.health <= 0 {
+				m.health = 0
+				m.dead = true
+				m.text = "You died!"
+				cmd = DeadCmd
+			}
+		}
 	}
 	return cmd
 }
 
+// hangUp is everything that happens when a player leaves, however they go
+func (m *model) hangUp() {
+	m.leaveFight("")
+	m.saveProfile()
+	m.app.worldState.Forget(m.id)
+	m.send(unregisterMsg{
+		id: m.id,
+	})
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// the world can be swapped out by a reload, so hold it still while we look at it
+	m.app.WorldMutex.RLock()
+	defer m.app.WorldMutex.RUnlock()
+	m.tape.msg(msg)
+	next, cmd := m.update(msg)
+	m = next.(model)
+	// look at the rest of the server here rather than in View, so drawing a
+	// frame never writes to the recording
+	if m.fight != nil {
+		m.tape.sawFight(m.fightSeen)
+	}
+	m.players = m.tape.others(m.others)
+	return m, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		cmd  tea.Cmd
 		cmds []tea.Cmd
@@ -850,98 +1186,90 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		m.width = msg.Width
 
-	case HoleMsg:
-		if m.pos.world == msg.pos.world {
+	case reloadMsg:
+		m.reloaded()
+	case tileMsg:
+		if m.pos.world != msg.pos.world {
+			break
+		}
+		if msg.gone {
 			m.destroyed[msg.pos] = true
+		} else {
+			delete(m.destroyed, msg.pos)
 		}
 	case rerenderMsg:
-		for _, u := range msg.updates {
-			m2, cmd := m.Update(u)
-			if m3, ok := m2.(model); ok {
-				m = m3
-			}
-			cmds = append(cmds, cmd)
-		}
+		// somebody in the room did something; redraw, and see by their light
+		m.explore()
 
-	case HealingMsg:
-		m.inventory.Consume(ITEM_POTION)
-		m.updateOptions()
-		healing := Roll("2d4+2")
-		if healing > m.maxHealth-m.health {
-			healing = m.maxHealth - m.health
-		}
-		m.combattext = fmt.Sprintf("You healed for %d!", healing)
-		m.health += healing
-		cmd = EnemyCmd
+	case UseMsg:
+		m.use(msg.item)
 	case MeleeMsg:
-		m.updateOptions()
-		hit := m.playerAttack() >= m.enemy.ac
-		cmd = EnemyCmd
-		if !hit {
-			m.combattext = "You missed!"
-		} else {
-			dmg := m.playerDamage()
-			m.combattext = fmt.Sprintf("You dealt %d damage!", dmg)
-			m.enemy.health -= dmg
-			if m.enemy.health <= 0 {
-				m.enemy.health = 0
-				cmd = DefeatEnemyCmd
-			}
-		}
+		m.melee()
 	case DefeatEnemyMsg:
-		xpGained := m.enemy.level * 250
-		m.xp += xpGained
+		m.xp += msg.xp
+		leveled := false
 		for m.xp >= m.xpCurve(m.level+1) {
+			leveled = true
 			m.level++
 			m.send(levelMsg{
 				id:    m.id,
 				level: m.level,
 			})
-			rolledHealth := Roll("1d6+1")
+			rolledHealth := m.dice.Roll(m.class().hitDie)
 			m.maxHealth += rolledHealth
 			m.health += rolledHealth
 		}
-		base := m.xpCurve(m.level)
-		next := m.xpCurve(m.level + 1)
-		xpNeeded := next - base
-		xpHave := m.xp - base
-		m.percent = float64(xpHave) / float64(xpNeeded)
-		fmt.Printf("have %d want %d percent %f\n", xpHave, xpNeeded, m.percent)
-		m.state = OVERWORLD
-		m.destroyed[m.pos] = true
-		m.text = fmt.Sprintf("You defeated %s!", m.enemy.name)
-
-	case EnemyMsg:
-		hit := Roll(m.enemy.attack) >= m.enemy.ac
-		if !hit {
-			m.combattext = fmt.Sprintf("%s attacked, but missed!", m.enemy.name)
+		m.updateXpPercent()
+		m.text = fmt.Sprintf("You defeated %s!", msg.name)
+		if msg.last {
+			m.endCombat()
+			m.clear(msg.pos)
 		} else {
-			dmg := Roll(m.enemy.damage)
-			m.combattext = fmt.Sprintf("%s dealt %d damage!", m.enemy.name, dmg)
-			m.health -= dmg
+			m.updateOptions()
 		}
-		if m.health <= 0 {
-			m.health = 0
-			m.combattext = "You died!"
-			cmd = DeadCmd
-		} else {
-			cmd = YourTurnCmd
+		if leveled {
+			m.saveProfile()
+		}
+	case fightOverMsg:
+		m.endCombat()
+		m.text = msg.text
+		if msg.destroyed {
+			m.clear(msg.pos)
 		}
+	case fightMsg:
+		// somebody in the fight did something; just redraw
+
+	case EnemyMsg:
+		cmd = m.enemyTurn()
 
 	case DeadMsg:
 		m.pos = m.app.StartPos
+		m.enterRoom()
 		m.text = ""
-		m.state = OVERWORLD
+		m.endCombat()
+		m.dead = false
+		m.health = m.maxHealth
+		m.manaSpent = 0
+		m.statuses = nil
 		m.send(moveMsg{
 			id:  m.id,
 			pos: m.pos,
 		})
 
 	case YourTurnMsg:
-		m.combattext = ""
+		cmd = m.yourTurn()
+	case AbilityMsg:
+		m.useAbility(msg.target)
+	case CastMsg:
+		cmd = m.cast(msg.spell)
+	case HealedMsg:
+		if !m.dead {
+			m.heal(msg.amount)
+		}
 	case RunMsg:
+		m.leaveFight("You ran away!")
 		m.updateOptions()
-		m.state = OVERWORLD
+		m.endCombat()
 		m.pos = m.prev
 		m.send(moveMsg{
 			id:  m.id,
@@ -950,24 +1278,48 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case RespawnMsg:
 		m.falling = false
 		m.pos = m.roomStart
+		m.enterRoom()
 		m.text = ""
 		m.send(moveMsg{
 			id:  m.id,
 			pos: m.pos,
 		})
 	case DisconnectMsg:
-		m.send(DeadMsg{
-			id: m.id,
-		})
+		m.hangUp()
+	case kickMsg:
+		m.hangUp()
+		return m, tea.Quit
 	case ChatClearMsg:
 		if m.chattext == msg.msg {
 			m.chattext = ""
 			m.send(ChatMsg{
-				id:  m.id,
-				msg: "",
+				id:      m.id,
+				channel: CHANNEL_ROOM,
+				msg:     "",
 			})
 		}
+	case chatLineMsg:
+		cmd = m.heard(msg)
+	case mobMsg:
+		if msg.home.world == m.pos.world {
+			m.mobs[msg.home] = msg.pos
+			m.explore()
+		}
+	case mobBumpMsg:
+		m.bumped(msg)
 	case tea.KeyMsg:
+		if m.console.Focused() {
+			return m, m.updateConsole(msg)
+		}
+		if m.state == CREATING {
+			return m, m.updateCreation(msg)
+		}
+		if m.state == AIMING {
+			return m, m.updateAiming(msg)
+		}
+		if m.chat.Focused() {
+			return m, m.updateChat(msg)
+		}
 		if !m.chat.Focused() {
 			switch msg.String() {
 			// case "enter":
@@ -980,28 +1332,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "t":
 				m.chat.Focus()
 				return m, nil
+			case ":":
+				if m.admin {
+					m.console.Focus()
+					return m, nil
+				}
 			case " ":
-				if m.hacks {
-					m.text = m.pos.world
+				if m.admin {
+					m.runCommand("where")
 				}
 			case "0":
-				if m.hacks {
-					m.level++
-					m.send(levelMsg{
-						id:    m.id,
-						level: m.level,
-					})
-					rolledHealth := Roll("1d6+1")
-					m.maxHealth += rolledHealth
-					m.health += rolledHealth
-					m.text = "Level up (cheats)"
+				if m.admin {
+					m.runCommand(fmt.Sprintf("level %d", m.level+1))
 				}
 
 			case "i", "e", "esc", "q", "tab":
 				if m.state == OVERWORLD {
 					m.inventory.item = 0
 					m.state = IN_INVENTORY
-				} else if m.state == IN_INVENTORY {
+				} else if m.state == IN_INVENTORY || m.state == IN_SPELLS {
+					m.state = OVERWORLD
+				}
+			case "c":
+				if m.state == OVERWORLD && !m.falling && !m.dead {
+					m.openSpellbook()
+				} else if m.state == IN_SPELLS {
 					m.state = OVERWORLD
 				}
 			case "left", "h", "a":
@@ -1012,10 +1367,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				cmd = m.move(0, -1)
 			case "down", "j", "s":
 				cmd = m.move(0, 1)
+			case "f":
+				m.joinNearbyFight()
+			case "r":
+				m.startAiming()
 			case "ctrl+c":
-				m.send(DeadMsg{
-					id: m.id,
-				})
+				m.hangUp()
 				return m, tea.Quit
 			}
 		}
@@ -1025,33 +1382,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.inventory, cmd = m.inventory.Update(msg)
 		cmds = append(cmds, cmd)
 	}
-	if m.inCombatView() && len(m.combattext) == 0 {
-		m.picker, cmd = m.picker.Update(msg)
+	if m.state == IN_SPELLS {
+		m.spellbook, cmd = m.spellbook.Update(msg)
 		cmds = append(cmds, cmd)
 	}
-	if m.chat.Focused() {
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.String() {
-			case "enter":
-				m.send(ChatMsg{
-					id:  m.id,
-					msg: m.chat.Value(),
-				})
-				m.chattext = m.chat.Value()
-				m.chat.Blur()
-				m.chat.SetValue("")
-				var ChatClearCmd tea.Cmd = func() tea.Msg {
-					time.Sleep(time.Second * 2)
-					return ChatClearMsg{
-						msg: m.chattext,
-					}
-				}
-				return m, ChatClearCmd
-			}
-		}
+	if m.inCombatView() && m.canPick() {
+		m.picker, cmd = m.picker.Update(msg)
+		cmds = append(cmds, cmd)
 	}
-
 	// update chat
 	m.chat, cmd = m.chat.Update(msg)
 	cmds = append(cmds, cmd)
@@ -1060,17 +1398,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 type Player struct {
-	pos   Position
-	level int
-	chat  string
+	pos       Position
+	level     int
+	chat      string
+	character Character
 }
 
-func (m model) View() string {
-	var chatBubble = lipgloss.NewStyle().BorderStyle(lipgloss.NormalBorder()).
-		BorderForeground(lipgloss.Color("63"))
-	var mainBox = lipgloss.NewStyle().Width(40).Height(16).
-		BorderStyle(lipgloss.NormalBorder()).
-		BorderForeground(lipgloss.Color("63"))
+// others is everybody else in the room, in the same order every time
+func (m *model) others() []Player {
 	players := []Player{}
 	m.app.StateMutex.RLock()
 	for id, pos := range m.app.Positions {
@@ -1080,37 +1415,104 @@ func (m model) View() string {
 		if id == m.id {
 			continue
 		}
-		players = append(players, Player{pos: pos, level: m.app.Levels[id], chat: m.app.Chats[id]})
+		c, ok := m.app.Characters[id]
+		if !ok {
+			// still making a character
+			continue
+		}
+		players = append(players, Player{pos: pos, level: m.app.Levels[id], chat: m.app.Chats[id], character: c})
 	}
 	m.app.StateMutex.RUnlock()
+	sort.Slice(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		if a.pos.y != b.pos.y {
+			return a.pos.y < b.pos.y
+		}
+		if a.pos.x != b.pos.x {
+			return a.pos.x < b.pos.x
+		}
+		if a.level != b.level {
+			return a.level < b.level
+		}
+		if a.character.Name != b.character.Name {
+			return a.character.Name < b.character.Name
+		}
+		return a.chat < b.chat
+	})
+	return players
+}
+
+func (m model) View() string {
+	m.app.WorldMutex.RLock()
+	defer m.app.WorldMutex.RUnlock()
+	var chatBubble = lipgloss.NewStyle().BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("63"))
+	var mainBox = lipgloss.NewStyle().Width(40).Height(16).
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("63"))
+	var rosterBox = lipgloss.NewStyle().Width(18).Height(16).
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("63"))
+	players := m.players
 	var s string
-	if m.state == IN_INVENTORY {
+	if m.state == CREATING {
+		s = mainBox.Render(m.creation.View())
+	} else if m.state == IN_INVENTORY {
 		s = m.inventory.View()
 		s = mainBox.Render(s)
+	} else if m.state == IN_SPELLS {
+		s = mainBox.Render(m.spellbookView())
 	} else if !m.inCombatView() {
+		others := []Position{}
+		for _, p := range players {
+			others = append(others, p.pos)
+		}
+		lit := m.lit(m.lights(others))
 		for r, row := range m.app.world[m.pos.world] {
 		outer:
 			for c, cell := range row {
+				pos := Position{x: c, y: r, world: m.pos.world}
 				for r == m.pos.y && c == m.pos.x {
-					s += green("U")
+					s += m.character.View()
+					continue outer
+				}
+				_, destroyed := m.destroyed[pos]
+				if _, ok := m.mobs[pos]; ok {
+					// its tile's empty while it's out walking
+					destroyed = true
+				}
+				if !sees(lit, pos) {
+					if m.explored[pos] {
+						s += cell.remembered(m.app, destroyed, c, r)
+					} else {
+						s += " "
+					}
+					continue outer
+				}
+				if _, e, ok := m.enemyAt(pos); ok {
+					if m.state == AIMING && m.targets[m.aim] == pos {
+						s += m.app.aimedAt(e)
+					} else {
+						s += red(m.app.enemyGlyph(e))
+					}
 					continue outer
 				}
-				_, destroyed := m.destroyed[Position{x: c, y: r, world: m.pos.world}]
-				if (cell.isEnemy() || cell.isSecret()) && !destroyed {
-					s += cell.render(destroyed, c, r)
+				if cell.isSecret() && !destroyed {
+					s += cell.render(m.app, destroyed, c, r)
 					continue outer
 				}
 				for _, p := range players {
 					if r == p.pos.y && c == p.pos.x {
-						levelT := fmt.Sprintf("%d", p.level)
-						if p.level > 9 {
-							levelT = "+"
-						}
-						s += blue(levelT)
+						s += p.character.View()
 						continue outer
 					}
 				}
-				s += cell.render(destroyed, c, r)
+				if lit != nil && cell.render(m.app, destroyed, c, r) == " " {
+					// so you can tell what's lit
+					s += gray(".")
+					continue outer
+				}
+				s += cell.render(m.app, destroyed, c, r)
 			}
 			s += "\n"
 		}
@@ -1119,29 +1521,28 @@ func (m model) View() string {
 		s = mainBox.Render(s)
 		if m.allowchat {
 			if m.chattext != "" {
-				s = lipgloss.PlaceOverlay(m.pos.x, m.pos.y-2, chatBubble.Render(m.chattext), s)
+				s = lipgloss.PlaceOverlay(m.pos.x, m.pos.y-2, chatBubble.Render(m.character.render(m.character.Name+": ")+m.chattext), s)
 			}
 			for _, p := range players {
-				if p.chat != "" {
-					s = lipgloss.PlaceOverlay(p.pos.x, p.pos.y-2, chatBubble.Render(goaway.Censor(p.chat)), s)
+				if p.chat != "" && sees(lit, p.pos) {
+					s = lipgloss.PlaceOverlay(p.pos.x, p.pos.y-2, chatBubble.Render(p.character.render(p.character.Name+": ")+p.chat), s)
 				}
 			}
 		}
+		s = lipgloss.JoinHorizontal(lipgloss.Top, s, rosterBox.Render(m.rosterView(players)))
 	} else {
 		// combat oh no
 		if m.state == IN_COMBAT {
-			s += fmt.Sprintf("You entered combat with %s!\n\n", m.enemy.name)
-			s += fmt.Sprintf("Enemy health: %d / %d\n", m.enemy.health, m.enemy.maxhealth)
-			s += m.enemy.art + "\n"
+			s += m.combatView()
 		} else {
 			s += fmt.Sprintf("You see %s.\n\n", m.npc.name)
 			s += m.npc.art + "\n"
 			s += fmt.Sprintf("\"%s\"\n\n", m.npc.dialogue)
-		}
-		if len(m.combattext) > 0 {
-			s += m.combattext
-		} else {
-			s += m.picker.View()
+			if len(m.combattext) > 0 {
+				s += m.combattext
+			} else {
+				s += m.picker.View()
+			}
 		}
 		s = mainBox.Render(s)
 	}
@@ -1153,11 +1554,20 @@ func (m model) View() string {
 	xpBar += fmt.Sprintf("\n Level:  %d\n ", m.level)
 	healthBar += "  " + m.progressHealth.ViewAs(float64(m.health)/float64(m.maxHealth))
 	healthBar += fmt.Sprintf("\n  Health: %d / %d\n", m.health, m.maxHealth)
-	bars := lipgloss.JoinHorizontal(lipgloss.Top, xpBar, healthBar)
+	if len(m.statuses) > 0 {
+		healthBar += "  " + m.statuses.View() + "\n"
+	}
+	manaBar := "  " + m.progressMana.ViewAs(float64(m.mana())/float64(m.maxMana()))
+	manaBar += fmt.Sprintf("\n  Mana:   %d / %d\n", m.mana(), m.maxMana())
+	bars := lipgloss.JoinHorizontal(lipgloss.Top, xpBar, healthBar, manaBar)
 	s += bars
 	s += red(fmt.Sprintf("\n           %s", m.text)) + "\n"
-	if m.allowchat {
-		s += m.chat.View()
+	if m.console.Focused() {
+		s += m.console.View()
+	} else if m.state == CREATING {
+		s += gray("Chat opens once you've made a character")
+	} else if m.allowchat {
+		s += m.chatView()
 	} else {
 		s += gray("Chat disabled (press '!' to enable)")
 	}
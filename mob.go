@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Some enemies don't stay put. The bestiary says how each one moves: it
+// wanders about, patrols back and forth, or chases whoever it can see. The
+// server moves them all on a tick and tells everybody in the room where
+// they went. An enemy is still known by the tile it's painted on (its
+// home), so fights, tiles and wounds all work like they always did; it's
+// just drawn wherever it's got to.
+
+// How an enemy moves
+const (
+	MOVE_STILL  = ""
+	MOVE_WANDER = "wander"
+	MOVE_PATROL = "patrol"
+	MOVE_CHASE  = "chase"
+)
+
+const (
+	mobTick  = 700 * time.Millisecond
+	mobLeash = 6 // how far from home anything goes
+	mobSight = 6 // how close you have to be for a chaser to come after you
+	mobRest  = 4 // ticks a mob sits still after a fight
+)
+
+func validMove(move string) bool {
+	switch move {
+	case MOVE_STILL, MOVE_WANDER, MOVE_PATROL, MOVE_CHASE:
+		return true
+	}
+	return false
+}
+
+type mob struct {
+	home Position
+	pos  Position
+	c    byte
+	move string
+	dir  [2]int // which way it's patrolling
+	rest int
+}
+
+// mobMsg is an enemy in the room moving
+type mobMsg struct {
+	home Position
+	pos  Position
+}
+
+// mobBumpMsg is an enemy walking into the player
+type mobBumpMsg struct {
+	home Position
+	pos  Position
+	c    byte
+}
+
+// spawnMobs finds every enemy on the map that moves. The caller holds
+// WorldMutex, or has the app to itself.
+func (a *app) spawnMobs() {
+	a.mobs = map[Position]*mob{}
+	for world, room := range a.world {
+		for y, row := range room {
+			for x, cell := range row {
+				if !cell.isEnemy() {
+					continue
+				}
+				def, ok := a.bestiary[cell.toEnemy()]
+				if !ok || def.Move == MOVE_STILL {
+					continue
+				}
+				home := Position{world: world, x: x, y: y}
+				a.mobs[home] = &mob{home: home, pos: home, c: cell.toEnemy(), move: def.Move, dir: [2]int{1, 0}}
+			}
+		}
+	}
+}
+
+// roomMobs is where everything that moves in a room is right now
+func (a *app) roomMobs(world string) map[Position]Position {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	out := map[Position]Position{}
+	for home, mb := range a.mobs {
+		if home.world == world {
+			out[home] = mb.pos
+		}
+	}
+	return out
+}
+
+// mobAt finds what's standing at pos. The caller holds FightsMutex.
+func (a *app) mobAt(pos Position) (*mob, bool) {
+	for _, mb := range a.mobs {
+		if mb.pos == pos {
+			return mb, true
+		}
+	}
+	return nil, false
+}
+
+// watchMobs moves everything that moves, forever
+func (a *app) watchMobs() {
+	rng := rand.New(rand.NewSource(a.nextSeed()))
+	for {
+		time.Sleep(mobTick)
+		a.moveMobs(rng)
+	}
+}
+
+type bump struct {
+	id  string
+	msg mobBumpMsg
+}
+
+// moveMobs is one tick: every mob in a room with somebody in it takes a step
+func (a *app) moveMobs(rng *rand.Rand) {
+	a.WorldMutex.RLock()
+	defer a.WorldMutex.RUnlock()
+
+	// only players walking around count; anybody in a fight is busy
+	players := map[string]Position{}
+	a.StateMutex.RLock()
+	for id, pos := range a.Positions {
+		if _, ok := a.Characters[id]; ok {
+			players[id] = pos
+		}
+	}
+	a.StateMutex.RUnlock()
+	rooms := map[string]bool{}
+	for _, pos := range players {
+		rooms[pos.world] = true
+	}
+
+	moved := []mobMsg{}
+	bumps := []bump{}
+	a.FightsMutex.Lock()
+	for _, f := range a.fights {
+		f.mutex.Lock()
+		for _, id := range f.players() {
+			delete(players, id)
+		}
+		f.mutex.Unlock()
+	}
+	a.worldState.mutex.Lock()
+	for _, mb := range a.mobs {
+		if !rooms[mb.home.world] {
+			continue
+		}
+		if _, ok := a.fights[mb.home]; ok {
+			continue
+		}
+		rule := a.tileRule(TILE_ENEMY)
+		if rule.Scope == SCOPE_GLOBAL && a.worldState.isGone("", mb.home) {
+			// dead for everybody; it comes back at home
+			if mb.pos != mb.home {
+				mb.pos = mb.home
+				moved = append(moved, mobMsg{home: mb.home, pos: mb.pos})
+			}
+			continue
+		}
+		if mb.rest > 0 {
+			mb.rest--
+			continue
+		}
+		// who can see it at all
+		seen := map[string]Position{}
+		for id, pos := range players {
+			if pos.world == mb.home.world && !a.worldState.isGone(a.worldState.owner(rule.Scope, id), mb.home) {
+				seen[id] = pos
+			}
+		}
+		next, ok := a.mobStep(mb, seen, rng)
+		if !ok {
+			continue
+		}
+		mb.pos = next
+		moved = append(moved, mobMsg{home: mb.home, pos: mb.pos})
+		for id, pos := range seen {
+			if pos == next {
+				bumps = append(bumps, bump{id: id, msg: mobBumpMsg{home: mb.home, pos: next, c: mb.c}})
+			}
+		}
+	}
+	a.worldState.mutex.Unlock()
+	a.FightsMutex.Unlock()
+
+	for _, msg := range moved {
+		a.mobMoved(msg)
+	}
+	for _, b := range bumps {
+		a.sendTo(b.id, b.msg)
+	}
+}
+
+// mobMoved tells everybody in the room
+func (a *app) mobMoved(msg mobMsg) {
+	ids := []string{}
+	a.StateMutex.RLock()
+	for id, pos := range a.Positions {
+		if pos.world == msg.home.world {
+			ids = append(ids, id)
+		}
+	}
+	a.StateMutex.RUnlock()
+	for _, id := range ids {
+		a.sendTo(id, msg)
+	}
+}
+
+// mobStep is where a mob goes next, if anywhere. The caller holds
+// FightsMutex.
+func (a *app) mobStep(mb *mob, seen map[string]Position, rng *rand.Rand) (Position, bool) {
+	step := func(d [2]int) Position {
+		return Position{world: mb.pos.world, x: mb.pos.x + d[0], y: mb.pos.y + d[1]}
+	}
+	switch mb.move {
+	case MOVE_CHASE:
+		// go after whoever's closest
+		var target Position
+		best := -1
+		for _, pos := range seen {
+			dx, dy := pos.x-mb.pos.x, pos.y-mb.pos.y
+			if d := dx*dx + dy*dy; d <= mobSight*mobSight && (best < 0 || d < best) {
+				target, best = pos, d
+			}
+		}
+		if best < 0 {
+			break
+		}
+		dx, dy := sign(target.x-mb.pos.x), sign(target.y-mb.pos.y)
+		tries := [][2]int{{dx, 0}, {0, dy}}
+		if abs(target.y-mb.pos.y) > abs(target.x-mb.pos.x) {
+			tries = [][2]int{{0, dy}, {dx, 0}}
+		}
+		for _, d := range tries {
+			if d != [2]int{0, 0} && a.mobCanEnter(mb, step(d)) {
+				return step(d), true
+			}
+		}
+		return Position{}, false
+	case MOVE_PATROL:
+		for i := 0; i < 2; i++ {
+			if next := step(mb.dir); a.mobCanEnter(mb, next) {
+				return next, true
+			}
+			mb.dir = [2]int{-mb.dir[0], -mb.dir[1]}
+		}
+		return Position{}, false
+	}
+	// wandering, or chasing with nobody around
+	if rng.Intn(2) == 0 {
+		return Position{}, false
+	}
+	dirs := [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+	d := dirs[rng.Intn(len(dirs))]
+	if next := step(d); a.mobCanEnter(mb, next) {
+		return next, true
+	}
+	return Position{}, false
+}
+
+// mobCanEnter is whether a mob can step onto pos. It goes anywhere a player
+// could walk without opening, falling into or fighting anything, and never
+// too far from home. The caller holds FightsMutex.
+func (a *app) mobCanEnter(mb *mob, pos Position) bool {
+	room := a.world[pos.world]
+	if pos.y < 0 || pos.y >= len(room) || pos.x < 0 || pos.x >= len(room[pos.y]) {
+		return false
+	}
+	if abs(pos.x-mb.home.x) > mobLeash || abs(pos.y-mb.home.y) > mobLeash {
+		return false
+	}
+	cell := room[pos.y][pos.x]
+	if cell.isWall() || cell.isFence() || cell.isNPC() || cell.isGate() || cell.isHole() || cell.isSecret() {
+		return false
+	}
+	if cell.isEnemy() && pos != mb.home {
+		return false
+	}
+	if other, ok := a.mobAt(pos); ok && other != mb {
+		return false
+	}
+	return true
+}
+
+// mobAt finds the enemy the player sees at pos, by its home
+func (m *model) mobAt(pos Position) (Position, bool) {
+	for home, at := range m.mobs {
+		if at == pos && !m.destroyed[home] {
+			return home, true
+		}
+	}
+	return Position{}, false
+}
+
+// enemyAt is whatever enemy the player sees at pos, moving or not, by the
+// tile it's painted on
+func (m *model) enemyAt(pos Position) (Position, byte, bool) {
+	if home, ok := m.mobAt(pos); ok {
+		cell := m.app.world[home.world][home.y][home.x]
+		return home, cell.toEnemy(), true
+	}
+	if _, ok := m.mobs[pos]; ok {
+		// it's off walking somewhere
+		return Position{}, 0, false
+	}
+	room := m.app.world[pos.world]
+	if pos.y < 0 || pos.y >= len(room) || pos.x < 0 || pos.x >= len(room[pos.y]) {
+		return Position{}, 0, false
+	}
+	cell := room[pos.y][pos.x]
+	if !cell.isEnemy() || m.destroyed[pos] {
+		return Position{}, 0, false
+	}
+	return pos, cell.toEnemy(), true
+}
+
+// enterRoomMobs fetches where everything that moves in the room is
+func (m *model) enterRoomMobs() {
+	m.mobs = m.tape.mobs(func() map[Position]Position {
+		return m.app.roomMobs(m.pos.world)
+	})
+}
+
+// bumped is an enemy walking into the player, which starts a fight if
+// they're around to have one
+func (m *model) bumped(msg mobBumpMsg) {
+	if msg.home.world != m.pos.world {
+		return
+	}
+	m.mobs[msg.home] = msg.pos
+	switch m.state {
+	case OVERWORLD, IN_INVENTORY, IN_SPELLS, AIMING:
+	default:
+		return
+	}
+	if m.dead || m.falling || m.destroyed[msg.home] || msg.pos != m.pos {
+		return
+	}
+	m.prev = m.pos
+	m.enterFight(msg.home, msg.c)
+	if def, ok := m.app.bestiary[msg.c]; ok {
+		m.text = fmt.Sprintf("%s came at you!", def.Name)
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
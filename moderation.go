@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goaway "github.com/TwiN/go-away"
+	"github.com/charmbracelet/log"
+)
+
+// Chat is cleaned up when the hub gets it, before anybody else sees it.
+// chat.json says which words get starred out on top of the usual list and
+// how fast people can talk. Talking too fast or saying the same thing over
+// and over gets refused, and keeping it up gets you muted for a while.
+//
+// Admin mutes and bans go in moderation.json so they outlast a restart, and
+// reports from players pile up in reports.jsonl.
+const (
+	chatRulesPath  = "./chat.json"
+	moderationPath = "./moderation.json"
+	reportsPath    = "./reports.jsonl"
+)
+
+const (
+	floodWindow = time.Minute // strikes older than this don't count
+	reportLines = 5           // what somebody said recently, for reports
+)
+
+type ChatRules struct {
+	Censor    []string `json:"censor"`     // more words to star out
+	Allow     []string `json:"allow"`      // words that look bad but aren't
+	Rate      float64  `json:"rate"`       // messages a second you can keep up
+	Burst     int      `json:"burst"`      // messages you can send all at once
+	Repeats   int      `json:"repeats"`    // saying the same thing this many times running is spam
+	Flood     int      `json:"flood"`      // refused this many times in a minute gets you muted
+	FloodMute string   `json:"flood_mute"` // for this long, like "2m"
+
+	floodMute time.Duration
+	detector  *goaway.ProfanityDetector
+}
+
+// loadChatRules reads chat.json and builds the filter
+func loadChatRules(path string) (*ChatRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules ChatRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var errs []error
+	if rules.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("%s: rate must be positive", path))
+	}
+	if rules.Burst < 1 {
+		errs = append(errs, fmt.Errorf("%s: burst must be at least 1", path))
+	}
+	if rules.Repeats < 2 {
+		errs = append(errs, fmt.Errorf("%s: repeats must be at least 2", path))
+	}
+	if rules.Flood < 1 {
+		errs = append(errs, fmt.Errorf("%s: flood must be at least 1", path))
+	}
+	if d, err := time.ParseDuration(rules.FloodMute); err != nil {
+		errs = append(errs, fmt.Errorf("%s: flood_mute: %w", path, err))
+	} else if d <= 0 {
+		errs = append(errs, fmt.Errorf("%s: flood_mute must be positive", path))
+	} else {
+		rules.floodMute = d
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	censor := append(append([]string{}, goaway.DefaultProfanities...), lower(rules.Censor)...)
+	allow := append(append([]string{}, goaway.DefaultFalsePositives...), lower(rules.Allow)...)
+	rules.detector = goaway.NewProfanityDetector().
+		WithCustomDictionary(censor, allow, goaway.DefaultFalseNegatives)
+	return &rules, nil
+}
+
+func lower(words []string) []string {
+	out := []string{}
+	for _, w := range words {
+		out = append(out, strings.ToLower(strings.TrimSpace(w)))
+	}
+	return out
+}
+
+// Sanction is a mute or a ban somebody handed out
+type Sanction struct {
+	Name   string    `json:"name"` // who they were at the time
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	When   time.Time `json:"when"`
+}
+
+// the part of moderation.json that's saved
+type sanctions struct {
+	Banned map[string]*Sanction `json:"banned"`
+	Muted  map[string]*Sanction `json:"muted"`
+}
+
+// Report is one player telling on another
+type Report struct {
+	When     time.Time `json:"when"`
+	From     string    `json:"from"`
+	FromKey  string    `json:"from_key"`
+	About    string    `json:"about"`
+	AboutKey string    `json:"about_key"`
+	Reason   string    `json:"reason"`
+	Said     []string  `json:"said"` // what they'd said lately
+}
+
+// talker is how somebody's been chatting lately
+type talker struct {
+	tokens  float64
+	last    time.Time
+	said    []string // newest last
+	strikes []time.Time
+	until   time.Time // muted for flooding until then
+}
+
+type Moderation struct {
+	mutex   sync.Mutex
+	rules   *ChatRules
+	path    string
+	reports string
+	list    sanctions
+	talkers map[string]*talker // by player id
+}
+
+func NewModeration(path string, reports string) *Moderation {
+	return &Moderation{
+		path:    path,
+		reports: reports,
+		list:    sanctions{Banned: map[string]*Sanction{}, Muted: map[string]*Sanction{}},
+		talkers: map[string]*talker{},
+	}
+}
+
+// banKey is what mutes and bans stick to: the player's key, or their address
+// if they didn't bring one
+func banKey(key string, addr net.Addr) string {
+	if key != "" {
+		return key
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return "ip:" + host
+}
+
+func (mod *Moderation) setRules(rules *ChatRules) {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	mod.rules = rules
+}
+
+// Load reads the saved mutes and bans. No file just means nobody's in trouble.
+func (mod *Moderation) Load() error {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	data, err := os.ReadFile(mod.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := sanctions{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", mod.path, err)
+	}
+	if list.Banned == nil {
+		list.Banned = map[string]*Sanction{}
+	}
+	if list.Muted == nil {
+		list.Muted = map[string]*Sanction{}
+	}
+	mod.list = list
+	return nil
+}
+
+// save needs the mutex held
+func (mod *Moderation) save() error {
+	if mod.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(mod.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := mod.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mod.path)
+}
+
+// Check decides whether a player gets to say something, and cleans it up if
+// they do
+func (mod *Moderation) Check(id string, text string, now time.Time) (string, error) {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	rules := mod.rules
+	if rules == nil {
+		return goaway.Censor(text), nil
+	}
+	t, ok := mod.talkers[id]
+	if !ok {
+		t = &talker{tokens: float64(rules.Burst), last: now}
+		mod.talkers[id] = t
+	}
+	if now.Before(t.until) {
+		return "", fmt.Errorf("You're muted for flooding for another %s.", t.until.Sub(now).Round(time.Second))
+	}
+	t.tokens += now.Sub(t.last).Seconds() * rules.Rate
+	if t.tokens > float64(rules.Burst) {
+		t.tokens = float64(rules.Burst)
+	}
+	t.last = now
+
+	var refused error
+	if t.tokens < 1 {
+		refused = fmt.Errorf("You're talking too fast.")
+	} else if t.repeating(text, rules.Repeats) {
+		refused = fmt.Errorf("You just said that.")
+	}
+	if refused != nil {
+		t.strike(now)
+		if len(t.strikes) >= rules.Flood {
+			t.strikes = nil
+			t.until = now.Add(rules.floodMute)
+			log.Warn("muted for flooding", "id", id, "for", rules.floodMute)
+			return "", fmt.Errorf("You're muted for flooding for %s.", rules.floodMute)
+		}
+		return "", refused
+	}
+
+	t.tokens--
+	t.said = append(t.said, text)
+	if len(t.said) > reportLines {
+		t.said = t.said[len(t.said)-reportLines:]
+	}
+	return rules.detector.Censor(text), nil
+}
+
+// repeating is whether this would be the same thing said repeats times running
+func (t *talker) repeating(text string, repeats int) bool {
+	if len(t.said) < repeats-1 {
+		return false
+	}
+	same := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	for _, s := range t.said[len(t.said)-(repeats-1):] {
+		if strings.Join(strings.Fields(strings.ToLower(s)), " ") != same {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *talker) strike(now time.Time) {
+	kept := []time.Time{}
+	for _, at := range t.strikes {
+		if now.Sub(at) < floodWindow {
+			kept = append(kept, at)
+		}
+	}
+	t.strikes = append(kept, now)
+}
+
+// Forget drops how somebody's been chatting once they leave
+func (mod *Moderation) Forget(id string) {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	delete(mod.talkers, id)
+}
+
+// Profane is for names, which get turned down instead of starred out
+func (mod *Moderation) Profane(text string) bool {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	if mod.rules == nil {
+		return goaway.IsProfane(text)
+	}
+	return mod.rules.detector.IsProfane(text)
+}
+
+func (mod *Moderation) Banned(key string) bool {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	_, ok := mod.list.Banned[key]
+	return ok
+}
+
+func (mod *Moderation) Muted(key string) bool {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	_, ok := mod.list.Muted[key]
+	return ok
+}
+
+func (mod *Moderation) Ban(key string, s *Sanction) error {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	mod.list.Banned[key] = s
+	return mod.save()
+}
+
+func (mod *Moderation) SetMuted(key string, s *Sanction) error {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	if s == nil {
+		delete(mod.list.Muted, key)
+	} else {
+		mod.list.Muted[key] = s
+	}
+	return mod.save()
+}
+
+// Unban lets somebody back in, found by their key or the name they were
+// banned under
+func (mod *Moderation) Unban(query string) (*Sanction, error) {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	key, err := findSanction(mod.list.Banned, query)
+	if err != nil {
+		return nil, err
+	}
+	s := mod.list.Banned[key]
+	delete(mod.list.Banned, key)
+	return s, mod.save()
+}
+
+// FindMuted is for unmuting somebody who isn't on right now
+func (mod *Moderation) FindMuted(query string) (string, error) {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	return findSanction(mod.list.Muted, query)
+}
+
+func findSanction(list map[string]*Sanction, query string) (string, error) {
+	if _, ok := list[query]; ok {
+		return query, nil
+	}
+	found := []string{}
+	for key, s := range list {
+		if strings.EqualFold(s.Name, query) || strings.HasPrefix(key, query) {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("Nobody on the list matches %q", query)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%q matches %d people on the list", query, len(found))
+}
+
+// Bans lists who's banned, for the console
+func (mod *Moderation) Bans() []string {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	out := []string{}
+	for key, s := range mod.list.Banned {
+		out = append(out, fmt.Sprintf("%s (%.12s)", s.Name, key))
+	}
+	return out
+}
+
+// Report writes a report down, with whatever the reported player said lately
+func (mod *Moderation) Report(r Report, id string) error {
+	mod.mutex.Lock()
+	defer mod.mutex.Unlock()
+	if t, ok := mod.talkers[id]; ok {
+		r.Said = append([]string{}, t.said...)
+	}
+	if mod.reports == "" {
+		return nil
+	}
+	file, err := os.OpenFile(mod.reports, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(r)
+}
+
+// reportMsg is a player telling the admins about somebody
+type reportMsg struct {
+	id     string
+	name   string
+	reason string
+}
+
+// moderate runs on the hub for everything anybody says
+func (a *app) moderate(id string, text string, at time.Time) (string, error) {
+	if a.muted[id] {
+		return "", fmt.Errorf("You're muted.")
+	}
+	return a.moderation.Check(id, text, at)
+}
+
+// report writes a report down and tells whichever admins are on. It runs on
+// the hub.
+func (a *app) report(msg reportMsg, at time.Time) {
+	if _, err := a.moderate(msg.id, "/report "+msg.name+" "+msg.reason, at); err != nil {
+		a.sendTo(msg.id, chatLineMsg{line: systemLine(err.Error())})
+		return
+	}
+	a.StateMutex.RLock()
+	about := ""
+	for id, c := range a.Characters {
+		if strings.EqualFold(c.Name, msg.name) && id != msg.id {
+			about = id
+		}
+	}
+	r := Report{
+		When:     at,
+		From:     a.Characters[msg.id].Name,
+		FromKey:  a.BanKeys[msg.id],
+		About:    a.Characters[about].Name,
+		AboutKey: a.BanKeys[about],
+		Reason:   msg.reason,
+	}
+	admins := []string{}
+	for id, key := range a.BanKeys {
+		if a.admins[key] {
+			admins = append(admins, id)
+		}
+	}
+	a.StateMutex.RUnlock()
+	if about == "" {
+		a.sendTo(msg.id, chatLineMsg{line: systemLine(fmt.Sprintf("Nobody called %s is here.", msg.name))})
+		return
+	}
+	if err := a.moderation.Report(r, about); err != nil {
+		log.Error("could not save report", "error", err)
+	}
+	log.Warn("report", "from", r.From, "about", r.About, "about_key", r.AboutKey, "reason", r.Reason)
+	a.sendTo(msg.id, chatLineMsg{line: systemLine(fmt.Sprintf("Thanks, the admins will look into %s.", r.About))})
+	for _, id := range admins {
+		a.sendTo(id, chatLineMsg{line: systemLine(fmt.Sprintf("%s reported %s: %s", r.From, r.About, r.Reason))})
+	}
+}
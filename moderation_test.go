@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testChatRules(t *testing.T) *ChatRules {
+	path := filepath.Join(t.TempDir(), "chat.json")
+	data := `{"censor": ["grue"], "allow": [], "rate": 1, "burst": 2, "repeats": 3, "flood": 3, "flood_mute": "2m"}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadChatRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestModerationCheck(t *testing.T) {
+	type say struct {
+		at   float64 // seconds in
+		text string
+		err  string // part of why it's refused, if it should be
+		want string // what everybody sees, if it isn't just text
+	}
+	tests := []struct {
+		name string
+		said []say
+	}{
+		{
+			name: "a burst and then too fast",
+			said: []say{
+				{at: 0, text: "one"},
+				{at: 0, text: "two"},
+				{at: 0, text: "three", err: "You're talking too fast."},
+				{at: 0.5, text: "three", err: "You're talking too fast."},
+				{at: 1, text: "three"},
+			},
+		},
+		{
+			name: "waiting doesn't save up more than a burst",
+			said: []say{
+				{at: 100, text: "one"},
+				{at: 100, text: "two"},
+				{at: 100, text: "three", err: "You're talking too fast."},
+			},
+		},
+		{
+			name: "saying the same thing over and over",
+			said: []say{
+				{at: 0, text: "hi"},
+				{at: 1, text: "hi"},
+				{at: 2, text: "  HI ", err: "You just said that."},
+				{at: 3, text: "bye"},
+				{at: 4, text: "hi"},
+			},
+		},
+		{
+			name: "it has to be running",
+			said: []say{
+				{at: 0, text: "hi"},
+				{at: 1, text: "bye"},
+				{at: 2, text: "hi"},
+			},
+		},
+		{
+			name: "flooding gets you muted",
+			said: []say{
+				{at: 0, text: "one"},
+				{at: 0, text: "two"},
+				{at: 0, text: "three", err: "You're talking too fast."},
+				{at: 0, text: "three", err: "You're talking too fast."},
+				{at: 0, text: "three", err: "You're muted for flooding for 2m0s."},
+				{at: 10, text: "sorry", err: "You're muted for flooding for another 1m50s."},
+				{at: 120, text: "sorry"},
+			},
+		},
+		{
+			name: "old strikes are forgotten",
+			said: []say{
+				{at: 0, text: "one"},
+				{at: 0, text: "two"},
+				{at: 0, text: "three", err: "You're talking too fast."},
+				{at: 0.5, text: "three", err: "You're talking too fast."},
+				{at: 70, text: "two"},
+				{at: 70, text: "two", err: "You just said that."},
+				{at: 71, text: "three"},
+			},
+		},
+		{
+			name: "words get starred out",
+			said: []say{
+				{at: 0, text: "look out for the grue", want: "look out for the ****"},
+			},
+		},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod := NewModeration("", "")
+			mod.setRules(testChatRules(t))
+			for i, s := range tt.said {
+				now := start.Add(time.Duration(s.at * float64(time.Second)))
+				got, err := mod.Check("player", s.text, now)
+				if s.err != "" {
+					if err == nil || err.Error() != s.err {
+						t.Fatalf("line %d: got error %v, want %q", i, err, s.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("line %d: %v", i, err)
+				}
+				want := s.want
+				if want == "" {
+					want = s.text
+				}
+				if got != want {
+					t.Fatalf("line %d: got %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestModerationCheckIsPerPlayer(t *testing.T) {
+	mod := NewModeration("", "")
+	mod.setRules(testChatRules(t))
+	now := time.Now()
+	for _, text := range []string{"one", "two"} {
+		if _, err := mod.Check("a", text, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mod.Check("a", "three", now); err == nil || !strings.Contains(err.Error(), "too fast") {
+		t.Fatalf("got %v, want too fast", err)
+	}
+	if _, err := mod.Check("b", "three", now); err != nil {
+		t.Fatalf("somebody else talking fast slowed b down: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -12,8 +11,13 @@ type PickerModel struct {
 	item  int
 }
 
+// PickerItem is one line of the menu. Picking it runs action, so the text
+// can say whatever it likes.
 type PickerItem struct {
-	text string
+	text     string
+	action   tea.Cmd
+	disabled bool   // shown, but picking it does nothing
+	tooltip  string // shown under the menu while it's picked
 }
 
 func (m *PickerModel) Init() tea.Cmd {
@@ -31,16 +35,10 @@ func (m *PickerModel) Update(msg tea.Msg) (PickerModel, tea.Cmd) {
 func (m *PickerModel) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 	switch msg.String() {
 	case "enter":
-		switch strings.Split(m.items[m.item].text, " ")[0] {
-		case "continue":
-			return RunCmd
-		case "run":
-			return RunCmd
-		case "melee":
-			return MeleeCmd
-		case "healing":
-			return HealingCmd
+		if m.item >= len(m.items) || m.items[m.item].disabled {
+			return nil
 		}
+		return m.items[m.item].action
 	case "up", "k", "w":
 		m.item--
 		if m.item < 0 {
@@ -58,11 +56,19 @@ func (m *PickerModel) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 func (m *PickerModel) View() string {
 	var out string
 	for i, item := range m.items {
-		if i == m.item {
+		switch {
+		case i == m.item && item.disabled:
+			out += gray(fmt.Sprintf("%s %s", ">", item.text)) + "\n"
+		case i == m.item:
 			out += blue(fmt.Sprintf("%s %s", ">", item.text)) + "\n"
-		} else {
+		case item.disabled:
+			out += gray(fmt.Sprintf("  %s", item.text)) + "\n"
+		default:
 			out += fmt.Sprintf("  %s\n", item.text)
 		}
 	}
+	if m.item < len(m.items) && m.items[m.item].tooltip != "" {
+		out += gray("  "+m.items[m.item].tooltip) + "\n"
+	}
 	return out
 }
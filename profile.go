@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+)
+
+// profiles live on disk as one json file per public key
+const profileDir = "./profiles"
+
+type ProfilePos struct {
+	World string `json:"world"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+}
+
+type ProfileItem struct {
+	ID       int  `json:"id"`
+	Qty      int  `json:"qty"`
+	Equipped bool `json:"equipped"`
+}
+
+type Profile struct {
+	Level     int           `json:"level"`
+	XP        int           `json:"xp"`
+	MaxHealth int           `json:"maxHealth"`
+	Inventory []ProfileItem `json:"inventory"`
+	Destroyed []ProfilePos  `json:"destroyed"`
+	Position  ProfilePos    `json:"position"`
+	Character *Character    `json:"character,omitempty"`
+	Spells    []string      `json:"spells,omitempty"`
+}
+
+type ProfileStore struct {
+	dir   string
+	mutex sync.Mutex
+	names map[string]string // lowercased character name -> the key that owns it
+}
+
+func NewProfileStore(dir string) *ProfileStore {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	ps := &ProfileStore{dir: dir, names: map[string]string{}}
+	ps.loadNames()
+	return ps
+}
+
+// loadNames works out who owns which name from the profiles already saved
+func (ps *ProfileStore) loadNames() {
+	paths, err := filepath.Glob(filepath.Join(ps.dir, "*.json"))
+	if err != nil {
+		log.Error("could not list profiles", "error", err)
+		return
+	}
+	for _, path := range paths {
+		key := strings.TrimSuffix(filepath.Base(path), ".json")
+		p, err := ps.Load(key)
+		if err != nil {
+			log.Error("could not load profile", "key", key, "error", err)
+			continue
+		}
+		if p == nil || p.Character == nil || p.Character.Name == "" {
+			continue
+		}
+		name := strings.ToLower(p.Character.Name)
+		if owner, ok := ps.names[name]; ok {
+			log.Warn("two profiles have the same name", "name", p.Character.Name, "keys", []string{owner, key})
+			continue
+		}
+		ps.names[name] = key
+	}
+}
+
+// Reserve keeps a name for whoever has key, even while they're off, and lets
+// go of the one they had before. It reports false if the name is already
+// somebody else's. Anonymous players can take any name nobody owns but
+// don't get to keep it.
+func (ps *ProfileStore) Reserve(name string, key string) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	name = strings.ToLower(name)
+	if owner, ok := ps.names[name]; ok {
+		return owner == key
+	}
+	if key == "" {
+		return true
+	}
+	for old, owner := range ps.names {
+		if owner == key {
+			delete(ps.names, old)
+		}
+	}
+	ps.names[name] = key
+	return true
+}
+
+// keyID turns a public key into something we can use as a filename
+func keyID(key ssh.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(key.Marshal())
+	return hex.EncodeToString(sum[:])
+}
+
+func (ps *ProfileStore) path(key string) string {
+	return filepath.Join(ps.dir, key+".json")
+}
+
+// Load returns nil if the player has never been seen before
+func (ps *ProfileStore) Load(key string) (*Profile, error) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	data, err := os.ReadFile(ps.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (ps *ProfileStore) Save(key string, p *Profile) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temp file first so a crash can't leave half a profile
+	tmp := ps.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path(key))
+}
+
+func toProfilePos(p Position) ProfilePos {
+	return ProfilePos{World: p.world, X: p.x, Y: p.y}
+}
+
+func (p ProfilePos) toPosition() Position {
+	return Position{world: p.World, x: p.X, y: p.Y}
+}
+
+func (m *model) toProfile() *Profile {
+	p := &Profile{
+		Level:     m.level,
+		XP:        m.xp,
+		MaxHealth: m.maxHealth,
+		Position:  toProfilePos(m.pos),
+		Spells:    m.spells,
+	}
+	if m.character.Name != "" {
+		c := m.character
+		p.Character = &c
+	}
+	for _, it := range m.inventory.items {
+		p.Inventory = append(p.Inventory, ProfileItem{
+			ID:       it.id,
+			Qty:      it.qty,
+			Equipped: it.equipped,
+		})
+	}
+	for _, pos := range m.app.playerTiles(m.id) {
+		p.Destroyed = append(p.Destroyed, toProfilePos(pos))
+	}
+	return p
+}
+
+func (m *model) applyProfile(p *Profile) {
+	m.level = p.Level
+	if p.Character != nil {
+		m.character = *p.Character
+	}
+	m.xp = p.XP
+	m.maxHealth = p.MaxHealth
+	m.health = p.MaxHealth
+	m.spells = nil
+	for _, spell := range p.Spells {
+		// skip spells that were taken out of the game
+		if validSpell(spell) && !m.knows(spell) {
+			m.spells = append(m.spells, spell)
+		}
+	}
+	m.inventory = Inventory{items: []InventoryItem{}}
+	for _, saved := range p.Inventory {
+		def, ok := m.app.items[saved.ID]
+		if !ok {
+			// the item was taken out of the game
+			continue
+		}
+		for i := 0; i < saved.Qty; i++ {
+			m.inventory.AddItem(def)
+		}
+		if saved.Equipped {
+			for i := range m.inventory.items {
+				if m.inventory.items[i].id == saved.ID {
+					m.inventory.items[i].equipped = true
+				}
+			}
+		}
+	}
+	destroyed := []Position{}
+	for _, pos := range p.Destroyed {
+		destroyed = append(destroyed, pos.toPosition())
+	}
+	m.app.restoreTiles(m.id, destroyed)
+	// the map might have changed since they left, so don't put them inside a
+	// wall, down a hole or on top of an enemy
+	pos := p.Position.toPosition()
+	if m.canReturnTo(pos) {
+		m.pos = pos
+		m.roomStart = pos
+	}
+	m.updateXpPercent()
+}
+
+// canReturnTo is whether a player can be put back where they were saved
+func (m *model) canReturnTo(pos Position) bool {
+	if !m.standable(pos) {
+		return false
+	}
+	cell := m.app.world[pos.world][pos.y][pos.x]
+	if !cell.isEnemy() && !cell.isHole() {
+		return true
+	}
+	// fine if they've already dealt with it
+	return m.app.roomState(pos.world, m.id)[pos]
+}
+
+// saveProfile writes the player to disk; anonymous players are not saved
+func (m *model) saveProfile() {
+	if m.key == "" {
+		return
+	}
+	if err := m.app.profiles.Save(m.key, m.toProfile()); err != nil {
+		log.Error("could not save profile", "key", m.key, "error", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Ranged weapons shoot enemies you can see from across the room. Walls,
+// fences, doors, secret walls nobody's found and other enemies are in the
+// way. Enemies that move are shot wherever they've got to. Shooting doesn't
+// start a fight, it just softens the enemy up: it stays hurt until somebody
+// walks up to it, and it takes a proper fight to finish it off.
+
+var crosshair = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Reverse(true).Render
+
+// aimedAt is an enemy drawn with the crosshair on it
+func (a *app) aimedAt(c byte) string {
+	return crosshair(a.enemyGlyph(c))
+}
+
+// fightAt is whether somebody's already fighting whatever's at pos
+func (a *app) fightAt(pos Position) bool {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	_, ok := a.fights[pos]
+	return ok
+}
+
+// woundEnemy puts dmg on the enemy at pos, leaving it at least 1 health,
+// and reports whether it's down to that
+func (a *app) woundEnemy(pos Position, c byte, dmg int) bool {
+	a.FightsMutex.Lock()
+	defer a.FightsMutex.Unlock()
+	def, ok := a.bestiary[c]
+	if !ok {
+		return false
+	}
+	a.wounds[pos] += dmg
+	if a.wounds[pos] < def.Health-1 {
+		return false
+	}
+	a.wounds[pos] = def.Health - 1
+	return true
+}
+
+// blocksSight is whether you can't shoot through pos
+func (m *model) blocksSight(pos Position) bool {
+	if _, _, ok := m.enemyAt(pos); ok {
+		return true
+	}
+	if m.destroyed[pos] {
+		// opened or found
+		return false
+	}
+	cell := m.app.world[pos.world][pos.y][pos.x]
+	return cell.isWall() || cell.isFence() || cell.isSecret() || cell.isGate()
+}
+
+// canSee is whether there's anything in the way of a shot at pos
+func (m *model) canSee(pos Position) bool {
+	return lineClear(m.pos, pos, m.blocksSight)
+}
+
+// shootable is every enemy in reach you can see, nearest first. In the
+// dark that's only what your own light shows.
+func (m *model) shootable(reach int) []Position {
+	out := []Position{}
+	lit := m.lit(m.myLight())
+	for y, row := range m.app.world[m.pos.world] {
+		for x := range row {
+			pos := Position{world: m.pos.world, x: x, y: y}
+			if _, _, ok := m.enemyAt(pos); !ok || pos == m.pos || !sees(lit, pos) {
+				continue
+			}
+			if m.distance(pos) <= reach*reach && m.canSee(pos) {
+				out = append(out, pos)
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return m.distance(out[i]) < m.distance(out[j])
+	})
+	return out
+}
+
+// distance is how far away pos is, squared
+func (m *model) distance(pos Position) int {
+	dx, dy := pos.x-m.pos.x, pos.y-m.pos.y
+	return dx*dx + dy*dy
+}
+
+// ammoFor is whether there's anything left to shoot, and what it's called
+func (m *model) ammoFor(weapon InventoryItem) (bool, string) {
+	return m.inventory.Count(weapon.ammo) > 0, strings.ToLower(m.app.item(weapon.ammo).Name)
+}
+
+func (m *model) startAiming() {
+	if m.state != OVERWORLD || m.falling || m.dead {
+		return
+	}
+	weapon, ok := m.inventory.Ranged()
+	if !ok {
+		m.text = "You don't have a ranged weapon out."
+		return
+	}
+	if ok, ammo := m.ammoFor(weapon); !ok {
+		m.text = fmt.Sprintf("You're out of %s.", ammo)
+		return
+	}
+	m.targets = m.shootable(weapon.reach)
+	if len(m.targets) == 0 {
+		m.text = "Nothing in range."
+		return
+	}
+	m.state = AIMING
+	m.aim = 0
+	m.aimText()
+}
+
+func (m *model) aimText() {
+	_, c, _ := m.enemyAt(m.targets[m.aim])
+	name := "something"
+	if def, ok := m.app.bestiary[c]; ok {
+		name = def.Name
+	}
+	m.text = fmt.Sprintf("Aiming at %s. enter shoots, tab next, esc stops", name)
+}
+
+// updateAiming handles keys while picking something to shoot
+func (m *model) updateAiming(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c":
+		m.hangUp()
+		return tea.Quit
+	case "tab", "right", "down", "l", "j", "d", "s":
+		m.aim = (m.aim + 1) % len(m.targets)
+		m.aimText()
+	case "shift+tab", "left", "up", "h", "k", "a", "w":
+		m.aim = (m.aim + len(m.targets) - 1) % len(m.targets)
+		m.aimText()
+	case "enter", "r":
+		m.shoot()
+	case "esc", "q":
+		m.state = OVERWORLD
+		m.text = ""
+	}
+	return nil
+}
+
+// rangedAttack is the attack roll for a ranged weapon
+func (m *model) rangedAttack(weapon InventoryItem) int {
+	return m.dice.Roll(d20.Plus(weapon.attackMod+m.inventory.ringMod()+m.class().attack)) + m.statuses.AttackMod()
+}
+
+// shoot fires at whatever's being aimed at
+func (m *model) shoot() {
+	m.state = OVERWORLD
+	target := m.targets[m.aim]
+	weapon, ok := m.inventory.Ranged()
+	if !ok {
+		return
+	}
+	if ok, ammo := m.ammoFor(weapon); !ok {
+		m.text = fmt.Sprintf("You're out of %s.", ammo)
+		return
+	}
+	home, c, ok := m.enemyAt(target)
+	if !ok || !m.canSee(target) || !sees(m.lit(m.myLight()), target) {
+		m.text = "You lost sight of it."
+		return
+	}
+	if m.tape.took(func() bool { return m.app.fightAt(home) }) {
+		m.text = "Somebody's already fighting that. Go help!"
+		return
+	}
+	m.inventory.Consume(weapon.ammo)
+	def, ok := m.app.bestiary[c]
+	if !ok {
+		return
+	}
+	if m.rangedAttack(weapon) < def.AC {
+		m.text = fmt.Sprintf("You missed %s.", def.Name)
+		return
+	}
+	dmg := m.dice.Roll(weapon.dmg) + m.statuses.DamageMod()
+	if dmg < 1 {
+		dmg = 1
+	}
+	m.text = fmt.Sprintf("You hit %s for %d damage!", def.Name, dmg)
+	if m.tape.took(func() bool { return m.app.woundEnemy(home, c, dmg) }) {
+		m.text = fmt.Sprintf("You hit %s for %d damage! It's barely standing.", def.Name, dmg)
+	}
+}
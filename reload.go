@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// how often we look at the world data for changes
+const reloadInterval = time.Second
+
+// everything a reload picks up
+var watched = []string{"./map", "./meta", "./art", bestiaryPath, itemsPath, tilesPath, chatRulesPath}
+
+// snapshot is a cheap fingerprint of some files and directories: names, sizes and mtimes
+func snapshot(dirs ...string) string {
+	var out string
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			out += fmt.Sprintf("%s:%d:%d\n", dir, info.Size(), info.ModTime().UnixNano())
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			out += fmt.Sprintf("%s:%d:%d\n", filepath.Join(dir, e.Name()), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return out
+}
+
+// watchLevels reloads the world whenever the files on disk change
+func (a *app) watchLevels() {
+	last := snapshot(watched...)
+	for {
+		time.Sleep(reloadInterval)
+		next := snapshot(watched...)
+		if next == last {
+			continue
+		}
+		last = next
+		// give the slicer a moment to finish writing everything
+		time.Sleep(reloadInterval / 2)
+		last = snapshot(watched...)
+		a.reloadLevels()
+	}
+}
+
+// reloadLevels builds a fresh copy of the world and swaps it in all at once.
+// If the new files don't load we keep playing on the old ones.
+func (a *app) reloadLevels() {
+	b := newApp()
+	if err := b.loadLevels(); err != nil {
+		log.Error("not reloading levels", "error", err)
+		return
+	}
+	for _, p := range b.validate() {
+		log.Warn("level problem", "problem", p.String())
+	}
+
+	a.WorldMutex.Lock()
+	a.world = b.world
+	a.links = b.links
+	a.dark = b.dark
+	a.dialogue = b.dialogue
+	a.bestiary = b.bestiary
+	a.items = b.items
+	a.tiles = b.tiles
+	a.StartPos = b.StartPos
+	a.FightsMutex.Lock()
+	a.mobs = b.mobs
+	a.FightsMutex.Unlock()
+	a.WorldMutex.Unlock()
+	a.moderation.setRules(b.moderation.rules)
+	log.Info("reloaded levels", "rooms", len(b.world))
+
+	a.broadcast(reloadMsg{})
+}
+
+// reloaded puts the player somewhere sensible if the room changed under them
+func (m *model) reloaded() {
+	defer m.enterRoom()
+	if m.standable(m.pos) {
+		return
+	}
+	if m.standable(m.roomStart) {
+		m.pos = m.roomStart
+	} else {
+		m.pos = m.app.StartPos
+		m.roomStart = m.pos
+	}
+	m.send(moveMsg{
+		id:  m.id,
+		pos: m.pos,
+	})
+}
+
+func (m *model) standable(pos Position) bool {
+	room, ok := m.app.world[pos.world]
+	if !ok {
+		return false
+	}
+	if pos.y < 0 || pos.y >= len(room) || pos.x < 0 || pos.x >= len(room[pos.y]) {
+		return false
+	}
+	cell := room[pos.y][pos.x]
+	return !cell.isWall() && !cell.isFence() && !cell.isNPC()
+}
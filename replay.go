@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/muesli/termenv"
+)
+
+// With RECORD=on every session is recorded so bug reports can be replayed.
+// Recordings have everything the player saw, whispers included, so it's off
+// unless you ask for it, and old ones get deleted after recordingsKeep.
+//
+// A recording is gzipped json, one entry per line: how the session started
+// (including its dice seed), every message fed to model.Update, and
+// everything the model asked the server along the way (which tiles in a
+// room were gone, where the room's enemies had wandered to, who was lighting
+// up a dark room, whether it got to a tile or a name first, who else was
+// standing around, how far its fight had got). Playing those back into a
+// fresh model gives the same View() frames without a server.
+//
+// What it can't bring back is other players' turns: a fight somebody else
+// was in, or a map that got reloaded halfway, replays from this player's
+// side only, and the replay says where it stopped matching. The chat cursor
+// doesn't blink in a replay either.
+const recordingsDir = "./recordings"
+
+// how long recordings stick around
+const recordingsKeep = 7 * 24 * time.Hour
+
+// pruneRecordings deletes old recordings every so often
+func (a *app) pruneRecordings() {
+	for {
+		entries, err := os.ReadDir(a.recordings)
+		if err != nil {
+			log.Error("could not list recordings", "error", err)
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || e.IsDir() || time.Since(info.ModTime()) < recordingsKeep {
+				continue
+			}
+			if err := os.Remove(filepath.Join(a.recordings, e.Name())); err != nil {
+				log.Error("could not delete recording", "error", err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// tape is where a model gets anything that depends on the rest of the
+// server. Live sessions just ask, recorded ones write the answers down, and
+// replays read them back.
+type tape interface {
+	msg(msg tea.Msg)
+	room(live func() map[Position]bool) map[Position]bool
+	mobs(live func() map[Position]Position) map[Position]Position
+	lights(live func() []Position) []Position
+	took(live func() bool) bool
+	sawFight(live func() fightSeen)
+	others(live func() []Player) []Player
+	close()
+}
+
+type liveTape struct{}
+
+func (liveTape) msg(msg tea.Msg) {}
+
+func (liveTape) room(live func() map[Position]bool) map[Position]bool { return live() }
+
+func (liveTape) mobs(live func() map[Position]Position) map[Position]Position { return live() }
+
+func (liveTape) lights(live func() []Position) []Position { return live() }
+
+func (liveTape) took(live func() bool) bool { return live() }
+
+func (liveTape) sawFight(live func() fightSeen) {}
+
+func (liveTape) others(live func() []Player) []Player { return live() }
+
+func (liveTape) close() {}
+
+type tapeKey struct {
+	Type  int    `json:"type"`
+	Runes string `json:"runes,omitempty"`
+	Alt   bool   `json:"alt,omitempty"`
+}
+
+type tapePlayer struct {
+	Pos       ProfilePos `json:"pos"`
+	Level     int        `json:"level"`
+	Chat      string     `json:"chat,omitempty"`
+	Character Character  `json:"character"`
+}
+
+type tapeStart struct {
+	ID      string    `json:"id"`
+	Term    string    `json:"term"`
+	Seed    int64     `json:"seed"`
+	Admin   bool      `json:"admin,omitempty"`
+	Profile *Profile  `json:"profile"`
+	When    time.Time `json:"when"`
+}
+
+// tapeMob is an enemy that moves, by home, and where it was
+type tapeMob struct {
+	Home ProfilePos `json:"home"`
+	Pos  ProfilePos `json:"pos"`
+}
+
+// fightSeen is what a session can tell about its fight from the outside.
+// Fights move on by themselves, so a replay uses it to keep up.
+type fightSeen struct {
+	Turns   int `json:"turns"`
+	Company int `json:"company,omitempty"` // other players in it
+}
+
+// tapeEntry is one line of a recording. Kind says which fields mean
+// anything.
+type tapeEntry struct {
+	At     int64        `json:"t"` // milliseconds since the session started
+	Kind   string       `json:"k"`
+	Start  *tapeStart   `json:"start,omitempty"`
+	Key    *tapeKey     `json:"key,omitempty"`
+	Width  int          `json:"w,omitempty"`
+	Height int          `json:"h,omitempty"`
+	Pos    *ProfilePos  `json:"pos,omitempty"`
+	To     *ProfilePos  `json:"to,omitempty"`
+	Text   string       `json:"text,omitempty"`
+	N      int          `json:"n,omitempty"`
+	Flag   bool         `json:"flag,omitempty"`
+	Gone   []ProfilePos `json:"gone,omitempty"`
+	Mobs   []tapeMob    `json:"mobs,omitempty"`
+	Lights []ProfilePos `json:"lights,omitempty"`
+	Seen   []ProfilePos `json:"seen,omitempty"`
+	Others []tapePlayer `json:"others,omitempty"`
+	Fight  *fightSeen   `json:"fight,omitempty"`
+	Line   *ChatLine    `json:"line,omitempty"`
+}
+
+// Kinds of entry that aren't messages
+const (
+	TAPE_START  = "start"
+	TAPE_ROOM   = "room"
+	TAPE_MOBS   = "mobs"
+	TAPE_LIGHTS = "lights"
+	TAPE_TOOK   = "took"
+	TAPE_FIGHT  = "fightstate"
+	TAPE_OTHERS = "others"
+	TAPE_END    = "end"
+	// a message that can't be played back, like the chat cursor blinking.
+	// It still gets a frame, so whatever was seen around it lands in the
+	// right place.
+	TAPE_SKIPPED = "skipped"
+)
+
+// messages with nothing in them are recorded by name alone
+var plainMsgs = map[string]tea.Msg{
+	"rerender":   rerenderMsg{},
+	"reload":     reloadMsg{},
+	"respawn":    RespawnMsg{},
+	"run":        RunMsg{},
+	"melee":      MeleeMsg{},
+	"enemy":      EnemyMsg{},
+	"yourturn":   YourTurnMsg{},
+	"fight":      fightMsg{},
+	"disconnect": DisconnectMsg{},
+	"dead":       DeadMsg{},
+	"kick":       kickMsg{},
+}
+
+func tapePos(pos Position) *ProfilePos {
+	p := toProfilePos(pos)
+	return &p
+}
+
+// encodeMsg turns a message into an entry, or reports false for anything
+// that isn't ours
+func encodeMsg(msg tea.Msg) (tapeEntry, bool) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return tapeEntry{Kind: "key", Key: &tapeKey{Type: int(msg.Type), Runes: string(msg.Runes), Alt: msg.Alt}}, true
+	case tea.WindowSizeMsg:
+		return tapeEntry{Kind: "size", Width: msg.Width, Height: msg.Height}, true
+	case tileMsg:
+		return tapeEntry{Kind: "tile", Pos: tapePos(msg.pos), Flag: msg.gone}, true
+	case DefeatEnemyMsg:
+		return tapeEntry{Kind: "defeat", Text: msg.name, N: msg.xp, Pos: tapePos(msg.pos), Flag: msg.last}, true
+	case fightOverMsg:
+		return tapeEntry{Kind: "fightover", Text: msg.text, Pos: tapePos(msg.pos), Flag: msg.destroyed}, true
+	case ChatClearMsg:
+		return tapeEntry{Kind: "chatclear", Text: msg.msg}, true
+	case AbilityMsg:
+		return tapeEntry{Kind: "ability", Text: msg.target}, true
+	case HealedMsg:
+		return tapeEntry{Kind: "healed", N: msg.amount}, true
+	case UseMsg:
+		return tapeEntry{Kind: "use", N: msg.item}, true
+	case CastMsg:
+		return tapeEntry{Kind: "cast", Text: msg.spell}, true
+	case mobMsg:
+		return tapeEntry{Kind: "mob", Pos: tapePos(msg.home), To: tapePos(msg.pos)}, true
+	case mobBumpMsg:
+		return tapeEntry{Kind: "bump", Pos: tapePos(msg.home), To: tapePos(msg.pos), N: int(msg.c)}, true
+	case chatLineMsg:
+		line := msg.line
+		return tapeEntry{Kind: "chatline", Line: &line, Flag: msg.yours}, true
+	}
+	for kind, plain := range plainMsgs {
+		if reflect.TypeOf(msg) == reflect.TypeOf(plain) {
+			return tapeEntry{Kind: kind}, true
+		}
+	}
+	return tapeEntry{}, false
+}
+
+func decodeMsg(e tapeEntry) (tea.Msg, bool) {
+	pos, to := Position{}, Position{}
+	if e.Pos != nil {
+		pos = e.Pos.toPosition()
+	}
+	if e.To != nil {
+		to = e.To.toPosition()
+	}
+	switch e.Kind {
+	case "key":
+		if e.Key == nil {
+			return nil, false
+		}
+		return tea.KeyMsg{Type: tea.KeyType(e.Key.Type), Runes: []rune(e.Key.Runes), Alt: e.Key.Alt}, true
+	case "size":
+		return tea.WindowSizeMsg{Width: e.Width, Height: e.Height}, true
+	case "tile":
+		return tileMsg{pos: pos, gone: e.Flag}, true
+	case "defeat":
+		return DefeatEnemyMsg{name: e.Text, xp: e.N, pos: pos, last: e.Flag}, true
+	case "fightover":
+		return fightOverMsg{text: e.Text, pos: pos, destroyed: e.Flag}, true
+	case "chatclear":
+		return ChatClearMsg{msg: e.Text}, true
+	case "ability":
+		return AbilityMsg{target: e.Text}, true
+	case "healed":
+		return HealedMsg{amount: e.N}, true
+	case "use":
+		return UseMsg{item: e.N}, true
+	case "cast":
+		return CastMsg{spell: e.Text}, true
+	case "mob":
+		return mobMsg{home: pos, pos: to}, true
+	case "bump":
+		return mobBumpMsg{home: pos, pos: to, c: byte(e.N)}, true
+	case "chatline":
+		if e.Line == nil {
+			return nil, false
+		}
+		return chatLineMsg{line: *e.Line, yours: e.Flag}, true
+	}
+	msg, ok := plainMsgs[e.Kind]
+	return msg, ok
+}
+
+func toTapePlayers(players []Player) []tapePlayer {
+	out := []tapePlayer{}
+	for _, p := range players {
+		out = append(out, tapePlayer{Pos: toProfilePos(p.pos), Level: p.level, Chat: p.chat, Character: p.character})
+	}
+	return out
+}
+
+func toTapeMobs(mobs map[Position]Position) []tapeMob {
+	out := []tapeMob{}
+	for home, pos := range mobs {
+		out = append(out, tapeMob{Home: toProfilePos(home), Pos: toProfilePos(pos)})
+	}
+	return out
+}
+
+func toMobs(mobs []tapeMob) map[Position]Position {
+	out := map[Position]Position{}
+	for _, mb := range mobs {
+		out[mb.Home.toPosition()] = mb.Pos.toPosition()
+	}
+	return out
+}
+
+func toTapePositions(set map[Position]bool) []ProfilePos {
+	out := []ProfilePos{}
+	for pos := range set {
+		out = append(out, toProfilePos(pos))
+	}
+	return out
+}
+
+func toPositions(gone []ProfilePos) map[Position]bool {
+	out := map[Position]bool{}
+	for _, pos := range gone {
+		out[pos.toPosition()] = true
+	}
+	return out
+}
+
+// Recorder writes a session down as it happens
+type Recorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	zip    *gzip.Writer
+	out    *json.Encoder
+	start  time.Time
+	seen   []tapePlayer // who else was around last time we wrote it down
+	fight  *fightSeen
+	closed bool
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// record starts recording a freshly made model
+func (a *app) record(m *model) (*Recorder, error) {
+	now := time.Now()
+	name := now.Format("20060102-150405") + "-" + unsafeName.ReplaceAllString(m.id, "_") + ".jsonl.gz"
+	file, err := os.Create(filepath.Join(a.recordings, name))
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: file, zip: gzip.NewWriter(file), start: now}
+	r.out = json.NewEncoder(r.zip)
+	r.write(tapeEntry{
+		Kind: TAPE_START,
+		Start: &tapeStart{
+			ID:      m.id,
+			Term:    m.term,
+			Seed:    m.seed,
+			Admin:   m.admin,
+			Profile: m.toProfile(),
+			When:    now,
+		},
+		Width:  m.width,
+		Height: m.height,
+		Gone:   toTapePositions(m.destroyed),
+		Mobs:   toTapeMobs(m.mobs),
+		Seen:   toTapePositions(m.explored),
+	})
+	return r, nil
+}
+
+func (r *Recorder) write(e tapeEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	e.At = time.Since(r.start).Milliseconds()
+	if err := r.out.Encode(e); err != nil {
+		// a broken recording shouldn't take the session down with it
+		log.Error("could not record session", "file", r.file.Name(), "error", err)
+		r.finish()
+	}
+}
+
+func (r *Recorder) msg(msg tea.Msg) {
+	e, ok := encodeMsg(msg)
+	if !ok {
+		e = tapeEntry{Kind: TAPE_SKIPPED}
+	}
+	r.write(e)
+}
+
+func (r *Recorder) room(live func() map[Position]bool) map[Position]bool {
+	destroyed := live()
+	e := tapeEntry{Kind: TAPE_ROOM}
+	for pos := range destroyed {
+		e.Gone = append(e.Gone, toProfilePos(pos))
+	}
+	r.write(e)
+	return destroyed
+}
+
+func (r *Recorder) mobs(live func() map[Position]Position) map[Position]Position {
+	mobs := live()
+	r.write(tapeEntry{Kind: TAPE_MOBS, Mobs: toTapeMobs(mobs)})
+	return mobs
+}
+
+func (r *Recorder) lights(live func() []Position) []Position {
+	lights := live()
+	e := tapeEntry{Kind: TAPE_LIGHTS, Lights: []ProfilePos{}}
+	for _, pos := range lights {
+		e.Lights = append(e.Lights, toProfilePos(pos))
+	}
+	r.write(e)
+	return lights
+}
+
+func (r *Recorder) took(live func() bool) bool {
+	took := live()
+	r.write(tapeEntry{Kind: TAPE_TOOK, Flag: took})
+	return took
+}
+
+// sawFight and others are only written down when they change
+
+func (r *Recorder) sawFight(live func() fightSeen) {
+	now := live()
+	r.mutex.Lock()
+	changed := r.fight == nil || *r.fight != now
+	r.fight = &now
+	r.mutex.Unlock()
+	if changed {
+		r.write(tapeEntry{Kind: TAPE_FIGHT, Fight: &now})
+	}
+}
+
+func (r *Recorder) others(live func() []Player) []Player {
+	players := live()
+	now := toTapePlayers(players)
+	r.mutex.Lock()
+	changed := !reflect.DeepEqual(now, r.seen)
+	if changed {
+		r.seen = now
+	}
+	r.mutex.Unlock()
+	if changed {
+		r.write(tapeEntry{Kind: TAPE_OTHERS, Others: now})
+	}
+	return players
+}
+
+func (r *Recorder) close() {
+	r.write(tapeEntry{Kind: TAPE_END})
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.finish()
+}
+
+// finish closes the file; the caller holds the mutex
+func (r *Recorder) finish() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.zip.Close()
+	r.file.Close()
+}
+
+// readTape loads a recording. One that was cut off partway, say by a crash,
+// is still good up to wherever it ends.
+func readTape(path string) ([]tapeEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zip, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	entries := []tapeEntry{}
+	in := json.NewDecoder(bufio.NewReader(zip))
+	for {
+		var e tapeEntry
+		err := in.Decode(&e)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 || entries[0].Kind != TAPE_START || entries[0].Start == nil {
+		return nil, fmt.Errorf("%s: doesn't start like a recording", path)
+	}
+	return entries, nil
+}
+
+// Playback feeds a recording's answers back to a model
+type Playback struct {
+	entries  []tapeEntry
+	next     int
+	players  []Player
+	diverged int // index of the first entry that didn't match, or -1
+	why      string
+}
+
+func (p *Playback) peek() (tapeEntry, bool) {
+	if p.next >= len(p.entries) {
+		return tapeEntry{}, false
+	}
+	return p.entries[p.next], true
+}
+
+// expect takes the next entry if it's the kind the model is asking for
+func (p *Playback) expect(kind string) (tapeEntry, bool) {
+	e, ok := p.peek()
+	if ok && e.Kind == kind {
+		p.next++
+		return e, true
+	}
+	p.diverge(fmt.Sprintf("the game asked for %q", kind))
+	return tapeEntry{}, false
+}
+
+func (p *Playback) diverge(why string) {
+	if p.diverged == -1 {
+		p.diverged = p.next
+		p.why = why
+	}
+}
+
+func (p *Playback) msg(msg tea.Msg) {}
+
+func (p *Playback) room(live func() map[Position]bool) map[Position]bool {
+	if e, ok := p.expect(TAPE_ROOM); ok {
+		return toPositions(e.Gone)
+	}
+	return live()
+}
+
+func (p *Playback) mobs(live func() map[Position]Position) map[Position]Position {
+	if e, ok := p.expect(TAPE_MOBS); ok {
+		return toMobs(e.Mobs)
+	}
+	return live()
+}
+
+func (p *Playback) lights(live func() []Position) []Position {
+	if e, ok := p.expect(TAPE_LIGHTS); ok {
+		lights := []Position{}
+		for _, pos := range e.Lights {
+			lights = append(lights, pos.toPosition())
+		}
+		return lights
+	}
+	return live()
+}
+
+func (p *Playback) took(live func() bool) bool {
+	if e, ok := p.expect(TAPE_TOOK); ok {
+		// still use the tile up here, so the replay's world keeps up
+		live()
+		return e.Flag
+	}
+	return live()
+}
+
+func (p *Playback) sawFight(live func() fightSeen) {}
+
+func (p *Playback) others(live func() []Player) []Player {
+	return p.players
+}
+
+func (p *Playback) close() {}
+
+type frame struct {
+	at   int64
+	what string
+	view string
+}
+
+// runReplay plays a recording back and prints every frame, or writes them
+// out as an asciicast
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	cast := flags.String("cast", "", "write an asciicast to this file instead of printing frames")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("usage: replay [-cast out.cast] recording.jsonl.gz")
+		return 2
+	}
+	entries, err := readTape(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	// same colours the server draws with
+	lipgloss.SetColorProfile(termenv.ANSI256)
+	a := newApp()
+	if err := a.loadLevels(); err != nil {
+		fmt.Println("load error:", err)
+		return 1
+	}
+	frames, tape := a.replay(entries)
+
+	start := entries[0]
+	if *cast != "" {
+		err = writeCast(*cast, start, frames)
+	} else {
+		for i, f := range frames {
+			fmt.Printf("--- frame %d at %s after %s\n%s\n\n", i, time.Duration(f.at)*time.Millisecond, f.what, f.view)
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d frames from %s, seed %d\n", len(frames), start.Start.ID, start.Start.Seed)
+	if tape.diverged != -1 {
+		fmt.Fprintf(os.Stderr, "stopped matching the recording at entry %d: %s\n", tape.diverged, tape.why)
+	}
+	return 0
+}
+
+// replay feeds a recording through a fresh model on a freshly loaded app and
+// returns a frame for the start and for every message
+func (a *app) replay(entries []tapeEntry) ([]frame, *Playback) {
+	start := entries[0]
+	// nobody's listening, but the model still publishes
+	go func() {
+		for range a.events {
+		}
+	}()
+	// fight turns move on when the recording says they did, not on a timer
+	timers := []func(){}
+	var timersMutex sync.Mutex
+	a.after = func(d time.Duration, f func()) {
+		timersMutex.Lock()
+		defer timersMutex.Unlock()
+		timers = append(timers, f)
+	}
+	runTimers := func() bool {
+		timersMutex.Lock()
+		due := timers
+		timers = nil
+		timersMutex.Unlock()
+		for _, f := range due {
+			f()
+		}
+		return len(due) > 0
+	}
+
+	a.WorldMutex.RLock()
+	m := a.newModel(start.Start.ID, "", start.Start.Term, start.Width, start.Height)
+	m.seed = start.Start.Seed
+	m.dice = NewDice(m.seed)
+	m.admin = start.Start.Admin
+	if start.Start.Profile != nil {
+		m.applyProfile(start.Start.Profile)
+	}
+	m.destroyed = toPositions(start.Gone)
+	m.mobs = toMobs(start.Mobs)
+	m.explored = toPositions(start.Seen)
+	tape := &Playback{entries: entries, next: 1, players: []Player{}, diverged: -1}
+	m.tape = tape
+	m.rejoin()
+	a.WorldMutex.RUnlock()
+
+	// catchUp takes in whatever the session saw before drawing its next frame
+	catchUp := func() {
+		for {
+			e, ok := tape.peek()
+			if !ok {
+				return
+			}
+			switch e.Kind {
+			case TAPE_OTHERS:
+				tape.players = []Player{}
+				for _, o := range e.Others {
+					tape.players = append(tape.players, Player{pos: o.Pos.toPosition(), level: o.Level, chat: o.Chat, character: o.Character})
+				}
+			case TAPE_FIGHT:
+				if e.Fight.Company > 0 {
+					tape.diverge("fought alongside other players")
+				}
+				for m.fight != nil && m.fightSeen().Turns < e.Fight.Turns && runTimers() {
+				}
+			default:
+				return
+			}
+			tape.next++
+		}
+	}
+
+	catchUp()
+	m.players = tape.players
+	frames := []frame{{at: 0, what: TAPE_START, view: m.View()}}
+	for {
+		e, ok := tape.peek()
+		if !ok || e.Kind == TAPE_END {
+			break
+		}
+		tape.next++
+		if e.Kind != TAPE_SKIPPED {
+			msg, ok := decodeMsg(e)
+			if !ok {
+				tape.diverge(fmt.Sprintf("nothing asked for %q", e.Kind))
+				continue
+			}
+			switch msg.(type) {
+			case YourTurnMsg, EnemyMsg:
+				// these only ever come from the turn moving on
+				runTimers()
+			}
+			next, _ := m.Update(msg)
+			m = next.(model)
+		}
+		catchUp()
+		// Update saw the players from before catching up
+		m.players = tape.players
+		frames = append(frames, frame{at: e.At, what: e.Kind, view: m.View()})
+	}
+	return frames, tape
+}
+
+// writeCast writes frames as an asciicast v2 file
+func writeCast(path string, start tapeEntry, frames []frame) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	width, height := start.Width, start.Height
+	if width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": start.Start.When.Unix(),
+		"title":     start.Start.ID,
+	})
+	fmt.Fprintf(out, "%s\n", header)
+	last := ""
+	for _, f := range frames {
+		if f.view == last {
+			continue
+		}
+		last = f.view
+		// clear the screen and draw the whole frame, like the alt screen does
+		data := "\x1b[H\x1b[2J" + strings.ReplaceAll(f.view, "\n", "\r\n")
+		line, _ := json.Marshal([]any{float64(f.at) / 1000, "o", data})
+		fmt.Fprintf(out, "%s\n", line)
+	}
+	return out.Flush()
+}
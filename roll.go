@@ -1,11 +1,246 @@
 package main
 
-import "github.com/justinian/dice"
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
 
-func Roll(what string) int {
-	result, _, err := dice.Roll(what)
+// Dice expressions look like 2d6+2: how many dice, how many sides, and a
+// flat bonus. They're parsed when the data is loaded, so nothing gets to a
+// fight that can't be rolled.
+type DiceExpr struct {
+	count int
+	sides int
+	mod   int
+}
+
+// the most dice anything is allowed to roll at once
+const maxDice = 100
+
+// how many of the latest rolls a session's dice remember
+const rollLogSize = 256
+
+var diceRe = regexp.MustCompile(`^\s*(\d+)d(\d+)\s*(?:([+-])\s*(\d+))?\s*$`)
+
+func ParseDice(what string) (DiceExpr, error) {
+	match := diceRe.FindStringSubmatch(what)
+	if match == nil {
+		return DiceExpr{}, fmt.Errorf("%q isn't a dice expression like 2d6+1", what)
+	}
+	count, _ := strconv.Atoi(match[1])
+	sides, _ := strconv.Atoi(match[2])
+	if count < 1 || count > maxDice {
+		return DiceExpr{}, fmt.Errorf("%q: need between 1 and %d dice", what, maxDice)
+	}
+	if sides < 1 {
+		return DiceExpr{}, fmt.Errorf("%q: dice need at least one side", what)
+	}
+	e := DiceExpr{count: count, sides: sides}
+	if match[4] != "" {
+		e.mod, _ = strconv.Atoi(match[4])
+		if match[3] == "-" {
+			e.mod = -e.mod
+		}
+	}
+	return e, nil
+}
+
+// mustDice is for expressions written into the code itself
+func mustDice(what string) DiceExpr {
+	e, err := ParseDice(what)
 	if err != nil {
 		panic(err)
 	}
-	return result.Int()
+	return e
+}
+
+func (e DiceExpr) empty() bool {
+	return e.count == 0
+}
+
+// Plus adds a flat bonus on top
+func (e DiceExpr) Plus(n int) DiceExpr {
+	e.mod += n
+	return e
+}
+
+func (e DiceExpr) String() string {
+	switch {
+	case e.mod > 0:
+		return fmt.Sprintf("%dd%d+%d", e.count, e.sides, e.mod)
+	case e.mod < 0:
+		return fmt.Sprintf("%dd%d-%d", e.count, e.sides, -e.mod)
+	}
+	return fmt.Sprintf("%dd%d", e.count, e.sides)
+}
+
+var d20 = mustDice("1d20")
+
+// How a roll is made
+const (
+	ROLL_NORMAL       = iota
+	ROLL_ADVANTAGE    // roll twice, keep the higher
+	ROLL_DISADVANTAGE // roll twice, keep the lower
+)
+
+// RollRecord is one roll, with every die that was thrown, so it can be
+// played back later
+type RollRecord struct {
+	Expr  string `json:"expr"`
+	Mode  int    `json:"mode,omitempty"`
+	Dice  []int  `json:"dice"`
+	Total int    `json:"total"`
+}
+
+// Dice is where every random number in a fight comes from. Each session has
+// its own, and so does each fight.
+type Dice interface {
+	Roll(e DiceExpr) int
+	RollMode(e DiceExpr, mode int) int
+	// Intn picks a number from 0 to n-1
+	Intn(n int) int
+	// Log is the most recent rolls, so a short stretch can be played back
+	Log() []RollRecord
+}
+
+// SeededDice rolls from its own random source, so the same seed always
+// rolls the same numbers
+type SeededDice struct {
+	mutex sync.Mutex
+	seed  int64
+	rng   *rand.Rand
+	log   []RollRecord // the latest rollLogSize rolls, wrapping around at next
+	next  int
+}
+
+func NewDice(seed int64) *SeededDice {
+	return &SeededDice{seed: seed, rng: rand.New(rand.NewSource(seed))}
+}
+
+// newSeed is for when nobody asked for a particular one
+func newSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+func (d *SeededDice) Seed() int64 {
+	return d.seed
+}
+
+// nextSeed gives a session its own seed, drawn from the server's so a whole
+// run can be reproduced from one seed
+func (a *app) nextSeed() int64 {
+	a.dice.mutex.Lock()
+	defer a.dice.mutex.Unlock()
+	return a.dice.rng.Int63()
+}
+
+func (d *SeededDice) Roll(e DiceExpr) int {
+	return d.RollMode(e, ROLL_NORMAL)
+}
+
+func (d *SeededDice) RollMode(e DiceExpr, mode int) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	throws := 1
+	if mode != ROLL_NORMAL {
+		throws = 2
+	}
+	rec := RollRecord{Expr: e.String(), Mode: mode}
+	for t := 0; t < throws; t++ {
+		total := e.mod
+		for i := 0; i < e.count; i++ {
+			die := d.rng.Intn(e.sides) + 1
+			rec.Dice = append(rec.Dice, die)
+			total += die
+		}
+		if t == 0 ||
+			(mode == ROLL_ADVANTAGE && total > rec.Total) ||
+			(mode == ROLL_DISADVANTAGE && total < rec.Total) {
+			rec.Total = total
+		}
+	}
+	if len(d.log) < rollLogSize {
+		d.log = append(d.log, rec)
+	} else {
+		d.log[d.next] = rec
+	}
+	d.next = (d.next + 1) % rollLogSize
+	return rec.Total
+}
+
+func (d *SeededDice) Intn(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return d.Roll(DiceExpr{count: 1, sides: n, mod: -1})
+}
+
+// Log is the latest rolls, oldest first
+func (d *SeededDice) Log() []RollRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.log) < rollLogSize {
+		return append([]RollRecord{}, d.log...)
+	}
+	return append(append([]RollRecord{}, d.log[d.next:]...), d.log[:d.next]...)
+}
+
+// ReplayDice plays back a roll log. As long as the game asks for the same
+// rolls in the same order it gets the same results; once it doesn't, the
+// replay has diverged and everything after that is rolled fresh.
+type ReplayDice struct {
+	mutex    sync.Mutex
+	records  []RollRecord
+	next     int
+	diverged int // index of the first roll that didn't match, or -1
+	fallback *SeededDice
+}
+
+func NewReplayDice(records []RollRecord) *ReplayDice {
+	return &ReplayDice{records: records, diverged: -1, fallback: NewDice(0)}
+}
+
+func (d *ReplayDice) Roll(e DiceExpr) int {
+	return d.RollMode(e, ROLL_NORMAL)
+}
+
+func (d *ReplayDice) RollMode(e DiceExpr, mode int) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.diverged == -1 && d.next < len(d.records) {
+		rec := d.records[d.next]
+		if rec.Expr == e.String() && rec.Mode == mode {
+			d.next++
+			return rec.Total
+		}
+		d.diverged = d.next
+	}
+	if d.diverged == -1 {
+		d.diverged = d.next
+	}
+	return d.fallback.RollMode(e, mode)
+}
+
+func (d *ReplayDice) Intn(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return d.Roll(DiceExpr{count: 1, sides: n, mod: -1})
+}
+
+func (d *ReplayDice) Log() []RollRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append(append([]RollRecord{}, d.records[:d.next]...), d.fallback.Log()...)
+}
+
+// Diverged reports where the replay stopped matching the log, if it did
+func (d *ReplayDice) Diverged() (int, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.diverged, d.diverged != -1
 }
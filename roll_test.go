@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		in   string
+		want DiceExpr
+		str  string
+		err  string // part of the error, if there should be one
+	}{
+		{in: "1d20", want: DiceExpr{count: 1, sides: 20}, str: "1d20"},
+		{in: "2d6+1", want: DiceExpr{count: 2, sides: 6, mod: 1}, str: "2d6+1"},
+		{in: "3d4-2", want: DiceExpr{count: 3, sides: 4, mod: -2}, str: "3d4-2"},
+		{in: " 1d8 + 3 ", want: DiceExpr{count: 1, sides: 8, mod: 3}, str: "1d8+3"},
+		{in: "2d6+0", want: DiceExpr{count: 2, sides: 6}, str: "2d6"},
+		{in: "100d1", want: DiceExpr{count: 100, sides: 1}, str: "100d1"},
+		{in: "", err: "isn't a dice expression"},
+		{in: "d6", err: "isn't a dice expression"},
+		{in: "2d", err: "isn't a dice expression"},
+		{in: "2d6+", err: "isn't a dice expression"},
+		{in: "2d6*2", err: "isn't a dice expression"},
+		{in: "-1d6", err: "isn't a dice expression"},
+		{in: "0d6", err: "need between 1 and 100 dice"},
+		{in: "101d6", err: "need between 1 and 100 dice"},
+		{in: "1d0", err: "dice need at least one side"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDice(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.str {
+				t.Errorf("prints as %q, want %q", got.String(), tt.str)
+			}
+		})
+	}
+}
+
+func TestSeededDice(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		mode int
+	}{
+		{"d20", "1d20", ROLL_NORMAL},
+		{"d20 with advantage", "1d20", ROLL_ADVANTAGE},
+		{"d20 with disadvantage", "1d20", ROLL_DISADVANTAGE},
+		{"bonus", "2d6+3", ROLL_NORMAL},
+		{"malus with advantage", "3d4-2", ROLL_ADVANTAGE},
+		{"one sided", "1d1", ROLL_NORMAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := mustDice(tt.expr)
+			a, b := NewDice(42), NewDice(42)
+			for i := 0; i < 200; i++ {
+				got := a.RollMode(e, tt.mode)
+				if again := b.RollMode(e, tt.mode); got != again {
+					t.Fatalf("roll %d: same seed rolled %d and %d", i, got, again)
+				}
+				if got < e.count+e.mod || got > e.count*e.sides+e.mod {
+					t.Fatalf("roll %d: %d is out of range for %s", i, got, e)
+				}
+			}
+			for i, rec := range a.Log() {
+				if rec.Expr != e.String() || rec.Mode != tt.mode {
+					t.Fatalf("record %d is %s mode %d", i, rec.Expr, rec.Mode)
+				}
+				throws := [][]int{rec.Dice}
+				if tt.mode != ROLL_NORMAL {
+					if len(rec.Dice) != 2*e.count {
+						t.Fatalf("record %d threw %d dice, want %d", i, len(rec.Dice), 2*e.count)
+					}
+					throws = [][]int{rec.Dice[:e.count], rec.Dice[e.count:]}
+				}
+				totals := []int{}
+				for _, dice := range throws {
+					total := e.mod
+					for _, die := range dice {
+						total += die
+					}
+					totals = append(totals, total)
+				}
+				want := totals[0]
+				switch {
+				case tt.mode == ROLL_ADVANTAGE && totals[1] > want,
+					tt.mode == ROLL_DISADVANTAGE && totals[1] < want:
+					want = totals[1]
+				}
+				if rec.Total != want {
+					t.Fatalf("record %d: total %d from %v, want %d", i, rec.Total, rec.Dice, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeededDiceIntn(t *testing.T) {
+	d := NewDice(7)
+	for _, n := range []int{0, 1, 2, 6, 100} {
+		for i := 0; i < 100; i++ {
+			got := d.Intn(n)
+			if got < 0 || (n > 0 && got >= n) || (n <= 1 && got != 0) {
+				t.Fatalf("Intn(%d) gave %d", n, got)
+			}
+		}
+	}
+}
+
+func TestSeededDiceLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		rolls int
+	}{
+		{"empty", 0},
+		{"a few", 3},
+		{"just full", rollLogSize},
+		{"wrapped", rollLogSize + 10},
+		{"wrapped twice", 2*rollLogSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDice(1)
+			for i := 0; i < tt.rolls; i++ {
+				// the number of sides says which roll it was
+				d.Roll(DiceExpr{count: 1, sides: i + 1})
+			}
+			log := d.Log()
+			want := tt.rolls
+			if want > rollLogSize {
+				want = rollLogSize
+			}
+			if len(log) != want {
+				t.Fatalf("log has %d rolls, want %d", len(log), want)
+			}
+			for i, rec := range log {
+				if want := (DiceExpr{count: 1, sides: tt.rolls - len(log) + i + 1}).String(); rec.Expr != want {
+					t.Fatalf("roll %d in the log is %s, want %s", i, rec.Expr, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayDice(t *testing.T) {
+	d20, d6 := mustDice("1d20"), mustDice("1d6")
+	live := NewDice(3)
+	want := []int{live.Roll(d20), live.RollMode(d20, ROLL_ADVANTAGE), live.Roll(d6)}
+	tests := []struct {
+		name     string
+		rolls    []DiceExpr
+		modes    []int
+		diverged int // -1 if it shouldn't
+	}{
+		{"same rolls", []DiceExpr{d20, d20, d6}, []int{ROLL_NORMAL, ROLL_ADVANTAGE, ROLL_NORMAL}, -1},
+		{"different mode", []DiceExpr{d20, d20, d6}, []int{ROLL_NORMAL, ROLL_NORMAL, ROLL_NORMAL}, 1},
+		{"different dice", []DiceExpr{d6}, []int{ROLL_NORMAL}, 0},
+		{"more rolls than the log", []DiceExpr{d20, d20, d6, d6}, []int{ROLL_NORMAL, ROLL_ADVANTAGE, ROLL_NORMAL, ROLL_NORMAL}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay := NewReplayDice(live.Log())
+			for i, e := range tt.rolls {
+				got := replay.RollMode(e, tt.modes[i])
+				if (tt.diverged == -1 || i < tt.diverged) && got != want[i] {
+					t.Fatalf("roll %d: got %d, want %d", i, got, want[i])
+				}
+			}
+			at, diverged := replay.Diverged()
+			if tt.diverged == -1 {
+				if diverged {
+					t.Fatalf("diverged at %d", at)
+				}
+				return
+			}
+			if !diverged || at != tt.diverged {
+				t.Fatalf("diverged at %d (%v), want %d", at, diverged, tt.diverged)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Spells are learned from scrolls lying around the world and cost mana to
+// cast. How much mana you have comes from your class and level; it trickles
+// back as you walk and the fountain fills it right up. Some spells only make
+// sense in a fight, some only out of one.
+
+// Spells
+const (
+	SPELL_FIREBOLT = "firebolt"
+	SPELL_CURE     = "cure wounds"
+	SPELL_WARD     = "ward"
+	SPELL_RECALL   = "recall"
+	SPELL_LIGHT    = "light"
+)
+
+// What a spell does
+const (
+	EFFECT_DAMAGE = iota
+	EFFECT_HEAL
+	EFFECT_BUFF
+	EFFECT_TELEPORT
+	EFFECT_LIGHT
+)
+
+type SpellDef struct {
+	about     string
+	cost      int
+	effect    int
+	dice      DiceExpr // damage or healing, plus your level
+	status    string   // buffs: on you, damage: on the enemy
+	turns     int
+	radius    int  // light: how far it reaches
+	combat    bool // can be cast in a fight
+	overworld bool // can be cast walking around
+}
+
+var spellDefs = map[string]SpellDef{
+	SPELL_FIREBOLT: {
+		about:  "2d6 fire damage, sets them burning",
+		cost:   2,
+		effect: EFFECT_DAMAGE,
+		dice:   mustDice("2d6"),
+		status: STATUS_BURNING,
+		turns:  2,
+		combat: true,
+	},
+	SPELL_CURE: {
+		about:     "heals 1d8",
+		cost:      2,
+		effect:    EFFECT_HEAL,
+		dice:      mustDice("1d8"),
+		combat:    true,
+		overworld: true,
+	},
+	SPELL_WARD: {
+		about:     "+3 AC for a while",
+		cost:      2,
+		effect:    EFFECT_BUFF,
+		status:    STATUS_WARDED,
+		turns:     5,
+		combat:    true,
+		overworld: true,
+	},
+	SPELL_RECALL: {
+		about:     "takes you back to the start",
+		cost:      3,
+		effect:    EFFECT_TELEPORT,
+		overworld: true,
+	},
+	SPELL_LIGHT: {
+		about:     "shows hidden walls nearby",
+		cost:      1,
+		effect:    EFFECT_LIGHT,
+		radius:    5,
+		overworld: true,
+	},
+}
+
+// every this many steps you get a point of mana back
+const manaRegenSteps = 6
+
+func validSpell(spell string) bool {
+	_, ok := spellDefs[spell]
+	return ok
+}
+
+// CastMsg is a player casting a spell they know
+type CastMsg struct {
+	spell string
+}
+
+func CastCmd(spell string) tea.Cmd {
+	return func() tea.Msg {
+		return CastMsg{spell: spell}
+	}
+}
+
+func (m *model) maxMana() int {
+	def := m.class()
+	return def.mana + def.manaPerLevel*(m.level-1)
+}
+
+func (m *model) mana() int {
+	if m.manaSpent > m.maxMana() {
+		return 0
+	}
+	return m.maxMana() - m.manaSpent
+}
+
+func (m *model) knows(spell string) bool {
+	for _, s := range m.spells {
+		if s == spell {
+			return true
+		}
+	}
+	return false
+}
+
+// learn reads a scroll and says what happened
+func (m *model) learn(spell string) string {
+	if m.knows(spell) {
+		return fmt.Sprintf("You already know %s. The scroll crumbles.", spell)
+	}
+	m.spells = append(m.spells, spell)
+	return fmt.Sprintf("You learned %s!", spell)
+}
+
+// spellOptions are the picker entries for casting, in a fight or out of one
+func (m *model) spellOptions(inFight bool) []PickerItem {
+	items := []PickerItem{}
+	for _, spell := range m.spells {
+		def := spellDefs[spell]
+		if inFight && !def.combat {
+			continue
+		}
+		item := PickerItem{
+			text:    fmt.Sprintf("cast %s (%d mana)", spell, def.cost),
+			action:  CastCmd(spell),
+			tooltip: def.about,
+		}
+		switch {
+		case !inFight && !def.overworld:
+			item.disabled = true
+			item.tooltip = "Only in a fight"
+		case m.mana() < def.cost:
+			item.disabled = true
+			item.tooltip = "Not enough mana"
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (m *model) openSpellbook() {
+	m.state = IN_SPELLS
+	m.spellbook = PickerModel{items: m.spellOptions(false)}
+}
+
+func (m *model) spellbookView() string {
+	s := fmt.Sprintf("Spellbook %s\n\n", gray(fmt.Sprintf("(%d / %d mana)", m.mana(), m.maxMana())))
+	if len(m.spells) == 0 {
+		return s + "You don't know any spells yet.\n" + gray("Scrolls will teach you some.")
+	}
+	return s + m.spellbook.View()
+}
+
+// cast casts a spell, in a fight or out of one
+func (m *model) cast(spell string) tea.Cmd {
+	def, ok := spellDefs[spell]
+	if !ok || !m.knows(spell) {
+		return nil
+	}
+	if m.state == IN_COMBAT {
+		m.castInFight(spell, def)
+		return nil
+	}
+	if m.state != IN_SPELLS || !def.overworld || m.dead || m.falling {
+		return nil
+	}
+	if m.mana() < def.cost {
+		m.text = "Not enough mana"
+		return nil
+	}
+	m.manaSpent += def.cost
+	m.state = OVERWORLD
+	switch def.effect {
+	case EFFECT_HEAL:
+		healing := m.heal(m.dice.Roll(def.dice.Plus(m.level)))
+		m.text = fmt.Sprintf("You healed for %d.", healing)
+	case EFFECT_BUFF:
+		m.statuses = m.statuses.Apply(def.status, def.turns)
+		m.text = fmt.Sprintf("You are %s.", def.status)
+	case EFFECT_TELEPORT:
+		m.pos = m.app.StartPos
+		m.roomStart = m.pos
+		m.enterRoom()
+		m.text = "You blinked back to the start."
+		m.send(moveMsg{
+			id:  m.id,
+			pos: m.pos,
+		})
+	case EFFECT_LIGHT:
+		found := m.light(def.radius)
+		switch found {
+		case 0:
+			m.text = "Nothing hidden around here."
+		case 1:
+			m.text = "The light shows a hidden wall!"
+		default:
+			m.text = fmt.Sprintf("The light shows %d hidden walls!", found)
+		}
+	}
+	return nil
+}
+
+// castInFight uses up the player's turn on a spell
+func (m *model) castInFight(spell string, def SpellDef) {
+	f := m.fight
+	if f == nil || !def.combat {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.myTurn(m.id) || m.mana() < def.cost {
+		return
+	}
+	m.manaSpent += def.cost
+	m.updateOptions()
+	switch def.effect {
+	case EFFECT_DAMAGE:
+		dmg := m.dice.Roll(def.dice.Plus(m.level))
+		text := fmt.Sprintf("You cast %s for %d damage!", spell, dmg)
+		if def.status != "" {
+			f.enemy.statuses = f.enemy.statuses.Apply(def.status, def.turns)
+			text += fmt.Sprintf("\n%s %s %s!", f.enemy.name, be(f.enemy.name), def.status)
+		}
+		m.damageEnemy(text, dmg)
+	case EFFECT_HEAL:
+		healing := m.heal(m.dice.Roll(def.dice.Plus(m.level)))
+		f.endTurn(m.id, fmt.Sprintf("You cast %s and healed for %d!", spell, healing))
+	case EFFECT_BUFF:
+		m.statuses = m.statuses.Apply(def.status, def.turns)
+		f.endTurn(m.id, fmt.Sprintf("You cast %s.\nYou are %s!", spell, def.status))
+	}
+}
+
+// light reveals secret walls within radius of the player and says how many
+// it found
+func (m *model) light(radius int) int {
+	found := 0
+	for y, row := range m.app.world[m.pos.world] {
+		for x, cell := range row {
+			dx, dy := x-m.pos.x, y-m.pos.y
+			if !cell.isSecret() || dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			pos := Position{world: m.pos.world, x: x, y: y}
+			if m.destroyed[pos] {
+				continue
+			}
+			if m.clear(pos) {
+				found++
+			}
+		}
+	}
+	return found
+}
+
+// regainMana is a step's worth of mana coming back
+func (m *model) regainMana() {
+	m.walked++
+	if m.walked%manaRegenSteps == 0 && m.manaSpent > 0 {
+		m.manaSpent--
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Status effects
+const (
+	STATUS_POISONED     = "poisoned"
+	STATUS_STUNNED      = "stunned"
+	STATUS_BLESSED      = "blessed"
+	STATUS_BURNING      = "burning"
+	STATUS_REGENERATING = "regenerating"
+	STATUS_WARDED       = "warded"
+)
+
+// How a status behaves when it's applied on top of itself
+const (
+	STACK_REFRESH   = iota // keep the longer duration
+	STACK_EXTEND           // add the durations together
+	STACK_INTENSIFY        // add a stack (up to maxStacks) and refresh
+)
+
+type StatusDef struct {
+	stacking  int
+	maxStacks int
+	damage    DiceExpr // rolled each turn, per stack
+	heals     DiceExpr // rolled each turn, per stack
+	attack    int      // added to attack rolls
+	ac        int      // added to armor class
+	dmgBonus  int      // added to damage dealt
+	skipTurn  bool
+	render    func(...string) string
+}
+
+var statusDefs = map[string]StatusDef{
+	STATUS_POISONED: {
+		stacking:  STACK_INTENSIFY,
+		maxStacks: 3,
+		damage:    mustDice("1d3"),
+		render:    green,
+	},
+	STATUS_STUNNED: {
+		stacking:  STACK_REFRESH,
+		maxStacks: 1,
+		skipTurn:  true,
+		ac:        -2,
+		render:    yellow,
+	},
+	STATUS_BLESSED: {
+		stacking:  STACK_REFRESH,
+		maxStacks: 1,
+		attack:    2,
+		ac:        1,
+		dmgBonus:  1,
+		render:    cyan,
+	},
+	STATUS_BURNING: {
+		stacking:  STACK_REFRESH,
+		maxStacks: 1,
+		damage:    mustDice("1d4"),
+		ac:        -1,
+		render:    red,
+	},
+	STATUS_REGENERATING: {
+		stacking:  STACK_EXTEND,
+		maxStacks: 1,
+		heals:     mustDice("1d3"),
+		render:    lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render,
+	},
+	STATUS_WARDED: {
+		stacking:  STACK_REFRESH,
+		maxStacks: 1,
+		ac:        3,
+		render:    blue,
+	},
+}
+
+type Status struct {
+	kind   string
+	turns  int
+	stacks int
+}
+
+// Statuses is everything currently affecting a player or an enemy
+type Statuses []Status
+
+func validStatus(kind string) bool {
+	_, ok := statusDefs[kind]
+	return ok
+}
+
+// Apply adds a status for some number of turns, following its stacking rule
+func (s Statuses) Apply(kind string, turns int) Statuses {
+	def, ok := statusDefs[kind]
+	if !ok || turns <= 0 {
+		return s
+	}
+	// the old slice can still be shared by copies of the model and by the
+	// recorder, so never change it in place
+	s = append(Statuses{}, s...)
+	for i := range s {
+		if s[i].kind != kind {
+			continue
+		}
+		switch def.stacking {
+		case STACK_REFRESH:
+			if turns > s[i].turns {
+				s[i].turns = turns
+			}
+		case STACK_EXTEND:
+			s[i].turns += turns
+		case STACK_INTENSIFY:
+			if s[i].stacks < def.maxStacks {
+				s[i].stacks++
+			}
+			if turns > s[i].turns {
+				s[i].turns = turns
+			}
+		}
+		return s
+	}
+	return append(s, Status{kind: kind, turns: turns, stacks: 1})
+}
+
+func (s Statuses) Has(kind string) bool {
+	for _, st := range s {
+		if st.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Statuses) sum(f func(StatusDef) int) int {
+	total := 0
+	for _, st := range s {
+		total += f(statusDefs[st.kind]) * st.stacks
+	}
+	return total
+}
+
+func (s Statuses) AttackMod() int {
+	return s.sum(func(d StatusDef) int { return d.attack })
+}
+
+func (s Statuses) ACMod() int {
+	return s.sum(func(d StatusDef) int { return d.ac })
+}
+
+func (s Statuses) DamageMod() int {
+	return s.sum(func(d StatusDef) int { return d.dmgBonus })
+}
+
+// Stunned means this turn gets skipped
+func (s Statuses) Stunned() bool {
+	for _, st := range s {
+		if statusDefs[st.kind].skipTurn {
+			return true
+		}
+	}
+	return false
+}
+
+// Tick runs one turn of every status. It returns the net change in health,
+// a line for each thing that happened, and whatever is left afterwards.
+func (s Statuses) Tick(who string, dice Dice) (int, []string, Statuses) {
+	delta := 0
+	lines := []string{}
+	left := Statuses{}
+	for _, st := range s {
+		def := statusDefs[st.kind]
+		for i := 0; i < st.stacks; i++ {
+			if !def.damage.empty() {
+				delta -= dice.Roll(def.damage)
+			}
+			if !def.heals.empty() {
+				delta += dice.Roll(def.heals)
+			}
+		}
+		st.turns--
+		if st.turns > 0 {
+			left = append(left, st)
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %s no longer %s.", who, be(who), st.kind))
+		}
+	}
+	if delta < 0 {
+		lines = append([]string{fmt.Sprintf("%s took %d damage from effects!", who, -delta)}, lines...)
+	} else if delta > 0 {
+		lines = append([]string{fmt.Sprintf("%s regenerated %d health.", who, delta)}, lines...)
+	}
+	return delta, lines, left
+}
+
+func be(who string) string {
+	if who == "You" {
+		return "are"
+	}
+	return "is"
+}
+
+func (s Statuses) View() string {
+	sorted := append(Statuses{}, s...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].kind < sorted[j].kind
+	})
+	parts := []string{}
+	for _, st := range sorted {
+		label := fmt.Sprintf("%s(%d)", st.kind, st.turns)
+		if st.stacks > 1 {
+			label = fmt.Sprintf("%s x%d(%d)", st.kind, st.stacks, st.turns)
+		}
+		parts = append(parts, statusDefs[st.kind].render(label))
+	}
+	return strings.Join(parts, " ")
+}
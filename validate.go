@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Problem is something wrong with the world data, found by validate
+type Problem struct {
+	world string
+	x     int
+	y     int
+	msg   string
+}
+
+func (p Problem) String() string {
+	if p.world == "" {
+		return p.msg
+	}
+	if p.x < 0 {
+		return fmt.Sprintf("room %s: %s", p.world, p.msg)
+	}
+	return fmt.Sprintf("room %s at %dx%d: %s", p.world, p.x, p.y, p.msg)
+}
+
+var directions = []string{"up", "right", "down", "left"}
+
+// runValidate loads the whole world and prints every problem it can find
+func runValidate() int {
+	a := newApp()
+	count := 0
+	if err := a.loadLevels(); err != nil {
+		for _, e := range unjoin(err) {
+			fmt.Println("load error:", e)
+			count++
+		}
+	}
+	for _, p := range a.validate() {
+		fmt.Println(p)
+		count++
+	}
+	if count > 0 {
+		fmt.Printf("\n%d problems found\n", count)
+		return 1
+	}
+	fmt.Println("world looks good")
+	return 0
+}
+
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func (a *app) validate() []Problem {
+	problems := []Problem{}
+	report := func(world string, x int, y int, format string, args ...any) {
+		problems = append(problems, Problem{world: world, x: x, y: y, msg: fmt.Sprintf(format, args...)})
+	}
+
+	worlds := []string{}
+	for world := range a.world {
+		worlds = append(worlds, world)
+	}
+	sort.Strings(worlds)
+
+	spawns := []Position{}
+	placed := map[int]bool{}
+	for _, world := range worlds {
+		links := a.links[world]
+		for dir, link := range links {
+			if link == "NONE" {
+				continue
+			}
+			if _, ok := a.world[link]; !ok {
+				report(world, -1, -1, "%s link points at missing room %s", directions[dir], link)
+				continue
+			}
+			back := a.links[link]
+			opposite := (dir + 2) % 4
+			if len(back) == 4 && back[opposite] != world {
+				report(world, -1, -1, "%s link goes to %s, but its %s link goes to %s", directions[dir], link, directions[opposite], back[opposite])
+			}
+		}
+
+		for y, row := range a.world[world] {
+			for x, cell := range row {
+				pos := Position{world: world, x: x, y: y}
+				if cell.isSpawn() {
+					spawns = append(spawns, pos)
+				}
+				if cell.isNPC() {
+					if _, ok := a.dialogue[pos]; !ok {
+						report(world, x, y, "NPC has no dialogue")
+					}
+					if !knownNPC(cell.toNPC()) {
+						report(world, x, y, "unknown NPC id %d", cell.toNPC())
+					}
+				}
+				if cell.isEnemy() && !a.knownEnemy(cell.toEnemy()) {
+					report(world, x, y, "unknown enemy id %d", cell.toEnemy())
+				}
+				if cell.isItem() {
+					placed[cell.toItem()] = true
+					if !a.knownItem(cell.toItem()) {
+						report(world, x, y, "unknown item id %d", cell.toItem())
+					}
+				}
+			}
+		}
+	}
+
+	// anything in the catalog that nobody can ever find
+	ids := []int{}
+	for id := range a.items {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if !placed[id] && id != ITEM_MUG {
+			report("", -1, -1, "item %d (%s) isn't anywhere in the world", id, a.items[id].Name)
+		}
+		if bane := a.items[id].Bane; bane != nil && !a.knownEnemy(byte(*bane)) {
+			report("", -1, -1, "item %d (%s) is the bane of unknown enemy %d", id, a.items[id].Name, *bane)
+		}
+	}
+
+	dialogue := []Position{}
+	for pos := range a.dialogue {
+		dialogue = append(dialogue, pos)
+	}
+	sort.Slice(dialogue, func(i, j int) bool {
+		if dialogue[i].world != dialogue[j].world {
+			return dialogue[i].world < dialogue[j].world
+		}
+		if dialogue[i].y != dialogue[j].y {
+			return dialogue[i].y < dialogue[j].y
+		}
+		return dialogue[i].x < dialogue[j].x
+	})
+	for _, pos := range dialogue {
+		room := a.world[pos.world]
+		if pos.y < 0 || pos.y >= len(room) || pos.x < 0 || pos.x >= len(room[pos.y]) {
+			report(pos.world, pos.x, pos.y, "dialogue is outside the room")
+			continue
+		}
+		if !room[pos.y][pos.x].isNPC() {
+			report(pos.world, pos.x, pos.y, "dialogue without an NPC")
+		}
+	}
+
+	switch len(spawns) {
+	case 0:
+		report("", -1, -1, "there is no spawn")
+	case 1:
+		seen := a.reachable(spawns[0])
+		if len(seen) == 1 {
+			report(spawns[0].world, spawns[0].x, spawns[0].y, "spawn is boxed in")
+		}
+		reached := map[string]bool{}
+		for pos := range seen {
+			reached[pos.world] = true
+		}
+		for _, world := range worlds {
+			if !reached[world] && !a.unused(world) {
+				report(world, -1, -1, "can't be reached from the spawn")
+			}
+		}
+	default:
+		for _, s := range spawns {
+			report(s.world, s.x, s.y, "one of %d spawns", len(spawns))
+		}
+	}
+	return problems
+}
+
+// reachable is every cell a player can walk to from start, across rooms
+func (a *app) reachable(start Position) map[Position]bool {
+	seen := map[Position]bool{start: true}
+	queue := []Position{start}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		for _, d := range [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}} {
+			next := Position{world: pos.world, x: pos.x + d[0], y: pos.y + d[1]}
+			room := a.world[next.world]
+			warp := -1
+			switch {
+			case next.y < 0:
+				warp, next.y = 0, len(room)-1
+			case next.x >= len(room[0]):
+				warp, next.x = 1, 0
+			case next.y >= len(room):
+				warp, next.y = 2, 0
+			case next.x < 0:
+				warp, next.x = 3, len(room[0])-1
+			}
+			if warp != -1 {
+				world, ok := a.linkedRoom(pos.world, warp)
+				if !ok {
+					continue
+				}
+				next.world = world
+			}
+			if seen[next] {
+				continue
+			}
+			if !walkable(a.world[next.world][next.y][next.x]) {
+				continue
+			}
+			seen[next] = true
+			queue = append(queue, next)
+		}
+	}
+	return seen
+}
+
+func walkable(cell Color) bool {
+	return !cell.isWall() && !cell.isFence() && !cell.isNPC() && !cell.isHole()
+}
+
+// unused is whether a room is a blank frame of the level sheet, all one
+// color, rather than somewhere anybody drew
+func (a *app) unused(world string) bool {
+	room := a.world[world]
+	for _, row := range room {
+		for _, cell := range row {
+			if cell != room[0][0] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (a *app) knownEnemy(id byte) bool {
+	_, ok := a.bestiary[id]
+	return ok
+}
+
+func knownNPC(id byte) bool {
+	return createNPC(id, "").name != "badcop_"
+}
+
+func (a *app) knownItem(id int) bool {
+	_, ok := a.items[id]
+	return ok
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	spawn := map[[2]int]rune{{1, 1}: '!'}
+	with := func(cells map[[2]int]rune, more map[[2]int]rune) map[[2]int]rune {
+		out := map[[2]int]rune{}
+		for at, glyph := range cells {
+			out[at] = glyph
+		}
+		for at, glyph := range more {
+			out[at] = glyph
+		}
+		return out
+	}
+	// a wall down the left side of a room
+	walledOff := map[[2]int]rune{}
+	for y := 0; y < 16; y++ {
+		walledOff[[2]int{0, y}] = '#'
+	}
+	back := []string{"NONE", "NONE", "NONE", "a"}
+	nine := 9
+
+	tests := []struct {
+		name     string
+		rooms    map[string]string
+		links    map[string][]string // rooms not in here don't link anywhere
+		dialogue map[Position]string
+		items    map[int]*ItemDef
+		want     []string
+	}{
+		{
+			name:  "two rooms side by side",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", nil)},
+			links: map[string][]string{"a": {"NONE", "b", "NONE", "NONE"}, "b": back},
+		},
+		{
+			name:  "no spawn",
+			rooms: map[string]string{"a": asciiRoom("", nil)},
+			want:  []string{"there is no spawn"},
+		},
+		{
+			name:  "two spawns",
+			rooms: map[string]string{"a": asciiRoom("", with(spawn, map[[2]int]rune{{2, 1}: '!'}))},
+			want:  []string{"room a at 1x1: one of 2 spawns", "room a at 2x1: one of 2 spawns"},
+		},
+		{
+			name:  "boxed in",
+			rooms: map[string]string{"a": asciiRoom("", with(spawn, map[[2]int]rune{{0, 1}: '#', {2, 1}: '+', {1, 2}: 'O'}))},
+			want:  []string{"room a at 1x1: spawn is boxed in"},
+		},
+		{
+			name:  "link to a missing room",
+			rooms: map[string]string{"a": asciiRoom("", spawn)},
+			links: map[string][]string{"a": {"NONE", "NONE", "c", "NONE"}},
+			want:  []string{"room a: down link points at missing room c"},
+		},
+		{
+			name:  "one way link",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", nil)},
+			links: map[string][]string{"a": {"NONE", "b", "NONE", "NONE"}},
+			want:  []string{"room a: right link goes to b, but its left link goes to NONE"},
+		},
+		{
+			name:  "npc without dialogue",
+			rooms: map[string]string{"a": asciiRoom("N npc 0\n", with(spawn, map[[2]int]rune{{5, 5}: 'N'}))},
+			want:  []string{"room a at 5x5: NPC has no dialogue"},
+		},
+		{
+			name:     "unknown npc",
+			rooms:    map[string]string{"a": asciiRoom("N npc 7\n", with(spawn, map[[2]int]rune{{5, 5}: 'N'}))},
+			dialogue: map[Position]string{{world: "a", x: 5, y: 5}: "hi"},
+			want:     []string{"room a at 5x5: unknown NPC id 7"},
+		},
+		{
+			name:  "dialogue without an npc",
+			rooms: map[string]string{"a": asciiRoom("", spawn)},
+			dialogue: map[Position]string{
+				{world: "a", x: 5, y: 5}:  "hi",
+				{world: "a", x: 50, y: 5}: "hi",
+			},
+			want: []string{"room a at 5x5: dialogue without an NPC", "room a at 50x5: dialogue is outside the room"},
+		},
+		{
+			name:  "unknown enemy",
+			rooms: map[string]string{"a": asciiRoom("b enemy 9\n", with(spawn, map[[2]int]rune{{5, 5}: 'b'}))},
+			want:  []string{"room a at 5x5: unknown enemy id 9"},
+		},
+		{
+			name:  "unknown item",
+			rooms: map[string]string{"a": asciiRoom("P item 4\n", with(spawn, map[[2]int]rune{{5, 5}: 'P'}))},
+			want:  []string{"room a at 5x5: unknown item id 4"},
+		},
+		{
+			name:  "item nobody can find",
+			rooms: map[string]string{"a": asciiRoom("P item 2\n", with(spawn, map[[2]int]rune{{5, 5}: 'P'}))},
+			items: map[int]*ItemDef{ITEM_MUG: {Name: "Mug"}, 2: {Name: "Sword"}, 3: {Name: "Rock"}},
+			want:  []string{"item 3 (Rock) isn't anywhere in the world"},
+		},
+		{
+			name:  "bane of an enemy that doesn't exist",
+			rooms: map[string]string{"a": asciiRoom("P item 2\n", with(spawn, map[[2]int]rune{{5, 5}: 'P'}))},
+			items: map[int]*ItemDef{2: {Name: "Sword", Bane: &nine}},
+			want:  []string{"item 2 (Sword) is the bane of unknown enemy 9"},
+		},
+		{
+			name:  "room with no way in",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", nil)},
+			want:  []string{"room b: can't be reached from the spawn"},
+		},
+		{
+			name:  "room walled off from its link",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", walledOff)},
+			links: map[string][]string{"a": {"NONE", "b", "NONE", "NONE"}, "b": back},
+			want:  []string{"room b: can't be reached from the spawn"},
+		},
+		{
+			name:  "blank frames don't count",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": strings.Repeat(strings.Repeat("#", 40)+"\n", 16)},
+		},
+		{
+			name:  "rooms join up through a third",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", nil), "c": asciiRoom("", nil)},
+			links: map[string][]string{"a": {"NONE", "NONE", "NONE", "b"}, "b": {"NONE", "a", "NONE", "c"}, "c": {"NONE", "b", "NONE", "NONE"}},
+		},
+		{
+			name:  "a wall along the edge blocks the way down",
+			rooms: map[string]string{"a": asciiRoom("", spawn), "b": asciiRoom("", nil)},
+			links: map[string][]string{"a": {"NONE", "NONE", "b", "NONE"}, "b": {"a", "NONE", "NONE", "NONE"}},
+			want:  []string{"room b: can't be reached from the spawn"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApp()
+			a.bestiary = map[byte]*EnemyDef{}
+			a.items = tt.items
+			for world, text := range tt.rooms {
+				room, err := parseAsciiLevel(strings.NewReader(text))
+				if err != nil {
+					t.Fatalf("room %s: %v", world, err)
+				}
+				a.addLevel(world, room)
+				a.links[world] = []string{"NONE", "NONE", "NONE", "NONE"}
+				if links, ok := tt.links[world]; ok {
+					a.links[world] = links
+				}
+			}
+			for pos, text := range tt.dialogue {
+				a.dialogue[pos] = text
+			}
+			got := []string{}
+			for _, p := range a.validate() {
+				got = append(got, p.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Anything on the map that can get used up (enemies, items, secrets, doors,
+// holes) is tracked by the server instead of by each player. tiles.json says
+// who else sees it gone once somebody uses it up: just you, your party or
+// everybody, and how long it takes to come back. The shipped tiles.json
+// keeps everything per-player apart from secrets; sharing with everybody is
+// opt-in.
+const tilesPath = "./tiles.json"
+
+// Who shares a tile
+const (
+	SCOPE_PLAYER = "player"
+	SCOPE_PARTY  = "party"
+	SCOPE_GLOBAL = "global"
+)
+
+// Kinds of tile that can be used up
+const (
+	TILE_ENEMY  = "enemy"
+	TILE_ITEM   = "item"
+	TILE_SECRET = "secret"
+	TILE_DOOR   = "door"
+	TILE_HOLE   = "hole"
+)
+
+// how often we check whether anything has come back
+const respawnInterval = time.Second
+
+type TileRule struct {
+	Scope   string `json:"scope"`
+	Respawn string `json:"respawn"` // like "5m"; empty means it stays gone
+
+	respawn time.Duration
+}
+
+// anything tiles.json doesn't mention stays per-player, like it used to be
+var defaultTileRule = &TileRule{Scope: SCOPE_PLAYER}
+
+func tileKind(c Color) string {
+	switch {
+	case c.isEnemy():
+		return TILE_ENEMY
+	case c.isItem():
+		return TILE_ITEM
+	case c.isSecret():
+		return TILE_SECRET
+	case c.isGate():
+		return TILE_DOOR
+	case c.isHole():
+		return TILE_HOLE
+	}
+	return ""
+}
+
+// loadTiles reads the rule for each kind of tile
+func loadTiles(path string) (map[string]*TileRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules map[string]*TileRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var errs []error
+	for kind, rule := range rules {
+		if err := rule.load(kind); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, kind, err))
+		}
+	}
+	return rules, errors.Join(errs...)
+}
+
+func (rule *TileRule) load(kind string) error {
+	switch kind {
+	case TILE_ENEMY, TILE_ITEM, TILE_SECRET, TILE_DOOR, TILE_HOLE:
+	default:
+		return fmt.Errorf("unknown tile")
+	}
+	switch rule.Scope {
+	case SCOPE_PLAYER, SCOPE_PARTY, SCOPE_GLOBAL:
+	default:
+		return fmt.Errorf("unknown scope %q", rule.Scope)
+	}
+	if rule.Respawn == "" {
+		return nil
+	}
+	if rule.Scope != SCOPE_GLOBAL {
+		return fmt.Errorf("only global tiles respawn")
+	}
+	d, err := time.ParseDuration(rule.Respawn)
+	if err != nil {
+		return fmt.Errorf("respawn: %w", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("respawn must be positive")
+	}
+	rule.respawn = d
+	return nil
+}
+
+func (a *app) tileRule(kind string) *TileRule {
+	if rule, ok := a.tiles[kind]; ok {
+		return rule
+	}
+	return defaultTileRule
+}
+
+type WorldState struct {
+	mutex   sync.Mutex
+	gone    map[string]map[Position]time.Time // by owner; zero means it's not coming back
+	parties map[string]string                 // player id -> whoever's party they're in
+}
+
+// a tile that came back
+type respawned struct {
+	owner string
+	pos   Position
+}
+
+func NewWorldState() *WorldState {
+	return &WorldState{
+		gone:    map[string]map[Position]time.Time{},
+		parties: map[string]string{},
+	}
+}
+
+func (ws *WorldState) party(id string) string {
+	if party, ok := ws.parties[id]; ok {
+		return party
+	}
+	return id
+}
+
+// owner is whose copy of the world a tile lives in
+func (ws *WorldState) owner(scope string, id string) string {
+	switch scope {
+	case SCOPE_GLOBAL:
+		return ""
+	case SCOPE_PARTY:
+		return "party:" + ws.party(id)
+	}
+	return "player:" + id
+}
+
+// sees is whether a player shares an owner's copy of the world
+func (ws *WorldState) sees(owner string, id string) bool {
+	return owner == "" || owner == "party:"+ws.party(id) || owner == "player:"+id
+}
+
+func (ws *WorldState) isGone(owner string, pos Position) bool {
+	_, ok := ws.gone[owner][pos]
+	return ok
+}
+
+// take uses up a tile, and reports false if somebody already beat you to it
+func (ws *WorldState) take(owner string, pos Position, respawn time.Duration) bool {
+	if ws.isGone(owner, pos) {
+		return false
+	}
+	if ws.gone[owner] == nil {
+		ws.gone[owner] = map[Position]time.Time{}
+	}
+	var back time.Time
+	if respawn > 0 {
+		back = time.Now().Add(respawn)
+	}
+	ws.gone[owner][pos] = back
+	return true
+}
+
+// JoinParty puts a player in the same party as leader
+func (ws *WorldState) JoinParty(id string, leader string) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	party := ws.party(leader)
+	if party != id {
+		ws.parties[id] = party
+		// whoever you joined is in it too, so it outlasts either of you leaving
+		ws.parties[leader] = party
+	}
+}
+
+// Forget drops everything that only mattered to this player, and their
+// party's copy of the world once nobody's left in it
+func (ws *WorldState) Forget(id string) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	party := ws.party(id)
+	delete(ws.gone, "player:"+id)
+	delete(ws.parties, id)
+	for _, p := range ws.parties {
+		if p == party {
+			return
+		}
+	}
+	delete(ws.gone, "party:"+party)
+}
+
+// Expire brings back everything whose timer has run out
+func (ws *WorldState) Expire(now time.Time) []respawned {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	out := []respawned{}
+	for owner, cells := range ws.gone {
+		for pos, back := range cells {
+			if !back.IsZero() && !now.Before(back) {
+				delete(cells, pos)
+				out = append(out, respawned{owner: owner, pos: pos})
+			}
+		}
+	}
+	return out
+}
+
+// roomState is which tiles in a room are gone, as this player sees it
+func (a *app) roomState(world string, id string) map[Position]bool {
+	out := map[Position]bool{}
+	a.worldState.mutex.Lock()
+	defer a.worldState.mutex.Unlock()
+	for y, row := range a.world[world] {
+		for x, cell := range row {
+			kind := tileKind(cell)
+			if kind == "" {
+				continue
+			}
+			pos := Position{world: world, x: x, y: y}
+			if a.worldState.isGone(a.worldState.owner(a.tileRule(kind).Scope, id), pos) {
+				out[pos] = true
+			}
+		}
+	}
+	return out
+}
+
+// clearTile uses up the tile at pos on behalf of a player and tells everyone
+// who shares it. It reports false if it was already gone.
+func (a *app) clearTile(id string, pos Position) bool {
+	kind := tileKind(a.world[pos.world][pos.y][pos.x])
+	if kind == "" {
+		return false
+	}
+	rule := a.tileRule(kind)
+	a.worldState.mutex.Lock()
+	owner := a.worldState.owner(rule.Scope, id)
+	took := a.worldState.take(owner, pos, rule.respawn)
+	a.worldState.mutex.Unlock()
+	if took {
+		a.tileChanged(owner, pos, true)
+	}
+	return took
+}
+
+// playerTiles is everything only this player has used up, for their profile
+func (a *app) playerTiles(id string) []Position {
+	a.worldState.mutex.Lock()
+	defer a.worldState.mutex.Unlock()
+	out := []Position{}
+	for pos := range a.worldState.gone["player:"+id] {
+		out = append(out, pos)
+	}
+	return out
+}
+
+// restoreTiles puts back what a player had used up last time they played.
+// Anything that isn't per-player anymore is left to the server.
+func (a *app) restoreTiles(id string, positions []Position) {
+	a.worldState.mutex.Lock()
+	defer a.worldState.mutex.Unlock()
+	for _, pos := range positions {
+		room, ok := a.world[pos.world]
+		if !ok || pos.y < 0 || pos.y >= len(room) || pos.x < 0 || pos.x >= len(room[pos.y]) {
+			continue
+		}
+		kind := tileKind(room[pos.y][pos.x])
+		if kind == "" || a.tileRule(kind).Scope != SCOPE_PLAYER {
+			continue
+		}
+		a.worldState.take("player:"+id, pos, 0)
+	}
+}
+
+// tileChanged tells everybody in the room who shares the tile
+func (a *app) tileChanged(owner string, pos Position, gone bool) {
+	ids := []string{}
+	a.StateMutex.RLock()
+	for id, p := range a.Positions {
+		if p.world == pos.world {
+			ids = append(ids, id)
+		}
+	}
+	a.StateMutex.RUnlock()
+	// work out who sees it under the lock, but don't hold it while sending
+	a.worldState.mutex.Lock()
+	seen := []string{}
+	for _, id := range ids {
+		if a.worldState.sees(owner, id) {
+			seen = append(seen, id)
+		}
+	}
+	a.worldState.mutex.Unlock()
+	for _, id := range seen {
+		a.sendTo(id, tileMsg{pos: pos, gone: gone})
+	}
+}
+
+// watchRespawns brings tiles back as their timers run out
+func (a *app) watchRespawns() {
+	for {
+		time.Sleep(respawnInterval)
+		for _, r := range a.worldState.Expire(time.Now()) {
+			a.tileChanged(r.owner, r.pos, false)
+		}
+	}
+}
+
+// enterRoom fetches the state of the room the player is now in
+func (m *model) enterRoom() {
+	m.destroyed = m.tape.room(func() map[Position]bool {
+		return m.app.roomState(m.pos.world, m.id)
+	})
+	m.enterRoomMobs()
+	m.explore()
+}
+
+// clear uses up the tile under pos, reporting false if it was already gone
+func (m *model) clear(pos Position) bool {
+	if pos.world == m.pos.world {
+		m.destroyed[pos] = true
+	}
+	return m.tape.took(func() bool {
+		return m.app.clearTile(m.id, pos)
+	})
+}